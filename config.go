@@ -1,6 +1,7 @@
 package mongokits
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strconv"
@@ -28,7 +29,10 @@ func (m *Config) Parse(prefix string) error {
 	m.Id = os.Getenv(pre + "MONGODB_ID")
 	to, err := strconv.Atoi(os.Getenv(pre + "MONGODB_TIMEOUT"))
 
-	fmt.Println(fmt.Sprintf("Mongodb Server[%s] Database[%s]", m.Server, m.Database))
+	if logger := defaultLogger(); logger.Enabled(context.Background(), LogInfo) {
+		logger.Log(context.Background(), LogInfo, "mongodb config parsed",
+			LogField{Key: LogFieldServer, Value: RedactURI(m.Server)}, LogField{Key: LogFieldDatabase, Value: m.Database})
+	}
 	if err != nil {
 		to = 5
 	}
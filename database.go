@@ -5,7 +5,8 @@ import (
 )
 
 type defaultManager struct {
-	factory DatabaseFactory
+	factory  DatabaseFactory
+	resolver TenantResolver
 }
 
 func (m *defaultManager) SetDatabaseFactory(df DatabaseFactory) {
@@ -26,6 +27,30 @@ func (m *defaultManager) GetDatabaseById(id string) (*MongodbDatabase, error) {
 	return m.factory.getDatabaseById(id)
 }
 
+func (m *defaultManager) SetTenantResolver(resolver TenantResolver) {
+	m.resolver = resolver
+}
+
+func (m *defaultManager) GetTenantDatabase(tenantId string) (*MongodbDatabase, error) {
+	if m.resolver == nil {
+		return m.GetDatabase()
+	}
+	datasourceId, databaseName, err := m.resolver(tenantId)
+	if err != nil {
+		return nil, err
+	}
+	var database *MongodbDatabase
+	if datasourceId == "" {
+		database, err = m.GetDatabase()
+	} else {
+		database, err = m.GetDatabaseById(datasourceId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return database.withDatabaseName(databaseName), nil
+}
+
 var defMgr *defaultManager
 
 func GetDefaultManager() DatabaseManager {
@@ -0,0 +1,255 @@
+package mongokits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"net"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrorDuplicateKey       = errors.New("duplicate key")
+	ErrorWriteConflict      = errors.New("write conflict")
+	ErrorTimeout            = errors.New("operation timeout")
+	ErrorNetwork            = errors.New("network error")
+	ErrorInvalidId          = errors.New("invalid document id")
+	ErrorValidationFailed   = errors.New("document validation failed")
+	ErrorVersionConflict    = errors.New("version conflict")
+	ErrorMigrationLocked    = errors.New("migration locked by another owner")
+	ErrorTenantRequired     = errors.New("tenant id required")
+	ErrorTenantMismatch     = errors.New("tenant mismatch")
+	ErrorShardKeyRequired   = errors.New("shard key required")
+	ErrorBulkEmpty          = errors.New("bulk operations is empty")
+	ErrorBulkNotExecuted    = errors.New("bulk operation not executed")
+	ErrorUnitOfWorkDatabase = errors.New("unit of work repositories must share the same database client")
+	ErrorBucketTimeRequired = errors.New("bucket time required")
+	ErrorTransactionClient  = errors.New("operation is not on the transaction client")
+)
+
+const (
+	codeDuplicateKey       = 11000
+	codeDuplicateKeyLegacy = 11001
+	codeDuplicateKeyCapped = 12582
+	codeWriteConflict      = 112
+	codeMaxTimeMSExpired   = 50
+	codeDocumentValidation = 121
+	codeExceededTimeLimit  = 262
+	codeNetworkTimeout     = 89
+	codeHostUnreachable    = 6
+	codeHostNotFound       = 7
+	codeSocketException    = 9001
+	labelNetworkError      = "NetworkError"
+)
+
+// KindError 将驱动返回的原始错误归类到包内的错误类型,errors.Is 可匹配 Kind,errors.As 可取到原始错误
+type KindError struct {
+	Kind error
+	Err  error
+}
+
+func (e *KindError) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Kind.Error(), e.Err.Error())
+}
+
+func (e *KindError) Is(target error) bool {
+	return e.Kind == target
+}
+
+func (e *KindError) Unwrap() error {
+	return e.Err
+}
+
+// DuplicateKeyError 唯一索引冲突,Index 为冲突的索引名称,Key 为冲突的键值
+type DuplicateKeyError struct {
+	Index string
+	Key   string
+	Err   error
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("duplicate key,index[%s] key[%s]", e.Index, e.Key)
+}
+
+func (e *DuplicateKeyError) Is(target error) bool {
+	return target == ErrorDuplicateKey
+}
+
+func (e *DuplicateKeyError) Unwrap() error {
+	return e.Err
+}
+
+var duplicateKeyPattern = regexp.MustCompile(`index: (\S+) dup key: (\{.*\})`)
+
+func newDuplicateKeyError(message string, err error) *DuplicateKeyError {
+	e := &DuplicateKeyError{Err: err}
+	if match := duplicateKeyPattern.FindStringSubmatch(message); len(match) == 3 {
+		e.Index = match[1]
+		e.Key = strings.TrimSpace(match[2])
+	}
+	return e
+}
+
+func newKindError(kind error, err error) error {
+	return &KindError{Kind: kind, Err: err}
+}
+
+// wrapError 将驱动错误转换为包内错误,无法归类的错误原样返回
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var kind *KindError
+	var dup *DuplicateKeyError
+	if errors.As(err, &kind) || errors.As(err, &dup) {
+		return err
+	}
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return newKindError(ErrorDocumentNotFound, err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newKindError(ErrorTimeout, err)
+	}
+
+	// 使用 errors.As 以便归类被 fmt.Errorf("%w") 等包装过的驱动错误
+	var writeException mongo.WriteException
+	var writeError mongo.WriteError
+	var writeConcernError mongo.WriteConcernError
+	var bulkException mongo.BulkWriteException
+	var commandError mongo.CommandError
+	var driverError driver.Error
+	var connectionError topology.ConnectionError
+	var netError net.Error
+	switch {
+	case errors.As(err, &writeException):
+		for _, we := range writeException.WriteErrors {
+			if kindErr := classifyCode(we.Code, we.Message, err); kindErr != nil {
+				return kindErr
+			}
+		}
+		if writeException.WriteConcernError != nil {
+			return classifyConcern(writeException.WriteConcernError, err)
+		}
+	case errors.As(err, &writeError):
+		if kindErr := classifyCode(writeError.Code, writeError.Message, err); kindErr != nil {
+			return kindErr
+		}
+	case errors.As(err, &writeConcernError):
+		return classifyConcern(&writeConcernError, err)
+	case errors.As(err, &bulkException):
+		for _, we := range bulkException.WriteErrors {
+			if kindErr := classifyCode(we.Code, we.Message, err); kindErr != nil {
+				return kindErr
+			}
+		}
+		if bulkException.WriteConcernError != nil {
+			return classifyConcern(bulkException.WriteConcernError, err)
+		}
+	case errors.As(err, &commandError):
+		if kindErr := classifyCode(int(commandError.Code), commandError.Message, err); kindErr != nil {
+			return kindErr
+		}
+		if commandError.HasErrorLabel(labelNetworkError) {
+			return newKindError(ErrorNetwork, err)
+		}
+	case errors.As(err, &driverError):
+		if kindErr := classifyCode(int(driverError.Code), driverError.Message, err); kindErr != nil {
+			return kindErr
+		}
+		if driverError.HasErrorLabel(labelNetworkError) {
+			return newKindError(ErrorNetwork, err)
+		}
+	case errors.As(err, &connectionError):
+		return newKindError(ErrorNetwork, err)
+	case errors.As(err, &netError):
+		if netError.Timeout() {
+			return newKindError(ErrorTimeout, err)
+		}
+		return newKindError(ErrorNetwork, err)
+	}
+	return err
+}
+
+// classifyConcern 归类写关注错误,无法归类时原样返回
+func classifyConcern(e *mongo.WriteConcernError, err error) error {
+	if kindErr := classifyCode(e.Code, e.Message, err); kindErr != nil {
+		return kindErr
+	}
+	return err
+}
+
+func classifyCode(code int, message string, err error) error {
+	switch code {
+	case codeDuplicateKey, codeDuplicateKeyLegacy, codeDuplicateKeyCapped:
+		return newDuplicateKeyError(message, err)
+	case codeWriteConflict:
+		return newKindError(ErrorWriteConflict, err)
+	case codeMaxTimeMSExpired, codeExceededTimeLimit, codeNetworkTimeout:
+		return newKindError(ErrorTimeout, err)
+	case codeDocumentValidation:
+		return newKindError(ErrorValidationFailed, err)
+	case codeHostUnreachable, codeHostNotFound, codeSocketException:
+		return newKindError(ErrorNetwork, err)
+	}
+	return nil
+}
+
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrorDocumentNotFound)
+}
+
+func IsDuplicateKey(err error) bool {
+	return errors.Is(err, ErrorDuplicateKey)
+}
+
+func IsWriteConflict(err error) bool {
+	return errors.Is(err, ErrorWriteConflict)
+}
+
+func IsTimeout(err error) bool {
+	return errors.Is(err, ErrorTimeout)
+}
+
+func IsNetworkError(err error) bool {
+	return errors.Is(err, ErrorNetwork)
+}
+
+func IsInvalidId(err error) bool {
+	return errors.Is(err, ErrorInvalidId)
+}
+
+func IsValidationFailed(err error) bool {
+	return errors.Is(err, ErrorValidationFailed)
+}
+
+func IsVersionConflict(err error) bool {
+	return errors.Is(err, ErrorVersionConflict)
+}
+
+func IsMigrationLocked(err error) bool {
+	return errors.Is(err, ErrorMigrationLocked)
+}
+
+func IsTenantRequired(err error) bool {
+	return errors.Is(err, ErrorTenantRequired)
+}
+
+func IsTenantMismatch(err error) bool {
+	return errors.Is(err, ErrorTenantMismatch)
+}
+
+func IsShardKeyRequired(err error) bool {
+	return errors.Is(err, ErrorShardKeyRequired)
+}
+
+func IsTransactionClient(err error) bool {
+	return errors.Is(err, ErrorTransactionClient)
+}
@@ -0,0 +1,108 @@
+package mongokits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+const dupMessage = `E11000 duplicate key error collection: test.users index: email_1 dup key: { email: "a@b.c" }`
+
+func TestWrapError(t *testing.T) {
+	plain := errors.New("plain")
+	for _, c := range []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{"no documents", mongo.ErrNoDocuments, ErrorDocumentNotFound},
+		{"wrapped no documents", fmt.Errorf("find: %w", mongo.ErrNoDocuments), ErrorDocumentNotFound},
+		{"deadline", context.DeadlineExceeded, ErrorTimeout},
+		{"write exception", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: codeDuplicateKey, Message: dupMessage}}}, ErrorDuplicateKey},
+		{"write exception concern", mongo.WriteException{WriteConcernError: &mongo.WriteConcernError{Code: codeExceededTimeLimit}}, ErrorTimeout},
+		{"write error", mongo.WriteError{Code: codeDocumentValidation}, ErrorValidationFailed},
+		{"write concern error", mongo.WriteConcernError{Code: codeMaxTimeMSExpired}, ErrorTimeout},
+		{"bulk write exception", mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Code: codeDuplicateKeyLegacy}}}}, ErrorDuplicateKey},
+		{"bulk write concern", mongo.BulkWriteException{WriteConcernError: &mongo.WriteConcernError{Code: codeWriteConflict}}, ErrorWriteConflict},
+		{"wrapped bulk write", fmt.Errorf("bulk: %w", mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Code: codeDuplicateKey}}}}), ErrorDuplicateKey},
+		{"command error", mongo.CommandError{Code: codeWriteConflict}, ErrorWriteConflict},
+		{"command network label", mongo.CommandError{Labels: []string{labelNetworkError}}, ErrorNetwork},
+		{"driver error", driver.Error{Code: codeHostUnreachable}, ErrorNetwork},
+		{"connection error", topology.ConnectionError{Wrapped: plain}, ErrorNetwork},
+		{"net timeout", &net.DNSError{IsTimeout: true}, ErrorTimeout},
+		{"net error", &net.DNSError{}, ErrorNetwork},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			wrapped := wrapError(c.err)
+			if !errors.Is(wrapped, c.kind) {
+				t.Fatalf("wrapError(%v) = %v, want kind %v", c.err, wrapped, c.kind)
+			}
+			if again := wrapError(wrapped); again != wrapped {
+				t.Fatalf("wrapError is not idempotent: %v", again)
+			}
+		})
+	}
+
+	if wrapError(nil) != nil {
+		t.Fatal("wrapError(nil) should be nil")
+	}
+	if got := wrapError(plain); got != plain {
+		t.Fatalf("unclassified error changed: %v", got)
+	}
+	if got := wrapError(mongo.WriteConcernError{Code: 1}); IsTimeout(got) || errors.As(got, new(*KindError)) {
+		t.Fatalf("unknown concern code classified: %v", got)
+	}
+}
+
+func TestClassifyCode(t *testing.T) {
+	for code, kind := range map[int]error{
+		codeDuplicateKey:       ErrorDuplicateKey,
+		codeDuplicateKeyLegacy: ErrorDuplicateKey,
+		codeDuplicateKeyCapped: ErrorDuplicateKey,
+		codeWriteConflict:      ErrorWriteConflict,
+		codeMaxTimeMSExpired:   ErrorTimeout,
+		codeExceededTimeLimit:  ErrorTimeout,
+		codeNetworkTimeout:     ErrorTimeout,
+		codeDocumentValidation: ErrorValidationFailed,
+		codeHostUnreachable:    ErrorNetwork,
+		codeHostNotFound:       ErrorNetwork,
+		codeSocketException:    ErrorNetwork,
+	} {
+		if err := classifyCode(code, "", errors.New("x")); !errors.Is(err, kind) {
+			t.Errorf("classifyCode(%d) = %v, want %v", code, err, kind)
+		}
+	}
+	if err := classifyCode(1, "", errors.New("x")); err != nil {
+		t.Errorf("classifyCode(1) = %v", err)
+	}
+}
+
+func TestDuplicateKeyError(t *testing.T) {
+	for _, c := range []struct {
+		message, index, key string
+	}{
+		{dupMessage, "email_1", `{ email: "a@b.c" }`},
+		{`E11000 duplicate key error index: test.users.$_id_ dup key: { : 1 }`, "test.users.$_id_", "{ : 1 }"},
+		{"E11000 duplicate key error", "", ""},
+	} {
+		cause := mongo.WriteError{Code: codeDuplicateKey, Message: c.message}
+		err := wrapError(mongo.WriteException{WriteErrors: mongo.WriteErrors{cause}})
+		var dup *DuplicateKeyError
+		if !errors.As(err, &dup) {
+			t.Fatalf("not a DuplicateKeyError: %v", err)
+		}
+		if dup.Index != c.index || dup.Key != c.key {
+			t.Errorf("parse %q: index %q key %q", c.message, dup.Index, dup.Key)
+		}
+		var raw mongo.WriteException
+		if !errors.As(err, &raw) || !IsDuplicateKey(err) {
+			t.Errorf("driver error not reachable through %v", err)
+		}
+	}
+}
@@ -12,6 +12,15 @@ type DatabaseManager interface {
 	SetDatabaseFactory(factory DatabaseFactory)
 }
 
+// TenantDatabaseManager 支持每租户独立数据库模式的 DatabaseManager,GetDefaultManager 返回的实现支持该接口
+type TenantDatabaseManager interface {
+	DatabaseManager
+	// SetTenantResolver 设置每租户独立数据库模式的解析器
+	SetTenantResolver(resolver TenantResolver)
+	// GetTenantDatabase 返回租户使用的数据源,未设置解析器时返回默认数据源
+	GetTenantDatabase(tenantId string) (*MongodbDatabase, error)
+}
+
 type DatabaseFactory interface {
 	getDatabase() (*MongodbDatabase, error)
 	getDatabaseById(id string) (*MongodbDatabase, error)
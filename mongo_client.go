@@ -21,6 +21,9 @@ type MongoClient struct {
 	database *mongo.Database
 	duration time.Duration
 	options  *MongoOptions
+	ctx      context.Context
+	// slow 最近的慢操作,未设置 SlowThreshold 时为 nil
+	slow *slowLog
 }
 
 /*
@@ -29,7 +32,7 @@ type MongoClient struct {
 */
 func GetClientByOptions(mongoOptions *MongoOptions) (*MongoClient, error) {
 	if client, exists := clients[mongoOptions.Id]; !exists {
-		database, err := createMongoDatabase(mongoOptions.server, mongoOptions.db, mongoOptions.timeout, mongoOptions.userName, mongoOptions.userPass)
+		database, err := createMongoDatabase(mongoOptions.server, mongoOptions.db, mongoOptions.timeout, mongoOptions.userName, mongoOptions.userPass, mongoOptions)
 		if err != nil {
 			return nil, err
 		}
@@ -38,7 +41,12 @@ func GetClientByOptions(mongoOptions *MongoOptions) (*MongoClient, error) {
 			duration: time.Duration(mongoOptions.timeout) * time.Second,
 			options:  mongoOptions,
 		}
+		if mongoOptions.slowThreshold > 0 {
+			client.slow = newSlowLog(mongoOptions.slowCapacity)
+		}
 		clients[mongoOptions.Id] = client
+		client.log(context.Background(), LogInfo, "mongodb connected",
+			LogField{Key: LogFieldServer, Value: RedactURI(mongoOptions.server)}, LogField{Key: LogFieldDatabase, Value: mongoOptions.db})
 		return client, nil
 	} else {
 		return client, nil
@@ -67,8 +75,15 @@ func GetClientByName(name string) (*MongoClient, error) {
 	}
 }
 
-func createMongoDatabase(server string, db string, timeout int, userName string, userPassword string) (*mongo.Database, error) {
-	client, err := mongo.NewClient(options.Client().ApplyURI(server))
+func createMongoDatabase(server string, db string, timeout int, userName string, userPassword string, mongoOptions *MongoOptions) (*mongo.Database, error) {
+	clientOptions := options.Client().ApplyURI(server)
+	if monitor := commandMonitor(mongoOptions.commandMonitors); monitor != nil {
+		clientOptions.SetMonitor(monitor)
+	}
+	if monitor := poolMonitor(mongoOptions.poolMonitors); monitor != nil {
+		clientOptions.SetPoolMonitor(monitor)
+	}
+	client, err := mongo.NewClient(clientOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -87,20 +102,49 @@ func createMongoDatabase(server string, db string, timeout int, userName string,
 func (client *MongoClient) GetCollection(tableName string) {
 	collections := client.database.Collection(tableName)
 
-	println(collections.Name())
+	client.log(context.Background(), LogDebug, "collection", LogField{Key: LogFieldCollection, Value: collections.Name()})
 }
 
 func (client *MongoClient) Save(tableName string, table interface{}) (interface{}, error) {
 	ctx := client.GetCtx()
-	result, err := client.database.Collection(tableName).InsertOne(ctx, table)
+	op := &Operation{Collection: tableName, Name: OpInsertOne, Document: table}
+	err := client.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		result, err := client.database.Collection(op.Collection).InsertOne(ctx, op.Document)
+		op.Result = result
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
+	}
+	result, ok := op.Result.(*mongo.InsertOneResult)
+	if !ok || result == nil {
+		return nil, nil
 	}
-	fmt.Println("Inserted a single document: ", result.InsertedID)
+	client.log(ctx, LogDebug, "inserted a single document", LogField{Key: LogFieldCollection, Value: tableName}, LogField{Key: "id", Value: result.InsertedID})
 	return result.InsertedID, nil
 }
 
+// findOneAndReplace 经拦截器链执行 FindOneAndReplace
+func (client *MongoClient) findOneAndReplace(ctx context.Context, tableName string, filter bson.M, document interface{}) error {
+	op := &Operation{Collection: tableName, Name: OpFindOneAndReplace, Filter: filter, Document: document}
+	return client.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		return client.database.Collection(op.Collection).FindOneAndReplace(ctx, op.Filter, op.Document).Err()
+	})
+}
+
 func (client *MongoClient) UpdateWithTransaction(tableName string, filter bson.M, document interface{}, handlers ...TransactionFunc) error {
+	if inTransaction(client.ctx, client.database.Client()) {
+		// 已处于 WithTransaction 的事务中,直接加入该事务
+		if err := client.findOneAndReplace(client.GetCtx(), tableName, filter, document); err != nil {
+			return wrapError(err)
+		}
+		for _, handler := range handlers {
+			if err := handler(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	//ctx := client.GetCtx()
 	ctx := context.Background()
 	var err error
@@ -117,7 +161,7 @@ func (client *MongoClient) UpdateWithTransaction(tableName string, filter bson.M
 	}
 
 	if err = mongo.WithSession(ctx, session, func(sc mongo.SessionContext) error {
-		if err := client.database.Collection(tableName).FindOneAndReplace(sc, filter, document).Err(); err != nil {
+		if err := client.findOneAndReplace(sc, tableName, filter, document); err != nil {
 			return err
 		}
 
@@ -133,25 +177,30 @@ func (client *MongoClient) UpdateWithTransaction(tableName string, filter bson.M
 		}
 		return nil
 	}); err != nil {
-		return err
+		return wrapError(err)
 	}
 	return nil
 }
 
 func (client *MongoClient) Update(tableName string, filter bson.M, setter bson.D) error {
-	ctx := client.GetCtx()
-	_, err := client.database.Collection(tableName).UpdateOne(ctx, filter, setter)
-	return err
+	op := &Operation{Collection: tableName, Name: OpUpdateOne, Filter: filter, Update: setter}
+	return wrapError(client.intercept(client.GetCtx(), op, func(ctx context.Context, op *Operation) error {
+		result, err := client.database.Collection(op.Collection).UpdateOne(ctx, op.Filter, op.Update)
+		op.Result = result
+		return err
+	}))
 }
 func (client *MongoClient) FindOneAndReplace(tableName string, filter bson.M, document interface{}) error {
-	ctx := client.GetCtx()
-	return client.database.Collection(tableName).FindOneAndReplace(ctx, filter, document).Err()
+	return wrapError(client.findOneAndReplace(client.GetCtx(), tableName, filter, document))
 }
 
 func (client *MongoClient) UpdateMany(tableName string, filter bson.M, setter interface{}) error {
-	ctx := client.GetCtx()
-	_, err := client.database.Collection(tableName).UpdateMany(ctx, filter, setter)
-	return err
+	op := &Operation{Collection: tableName, Name: OpUpdateMany, Filter: filter, Update: setter}
+	return wrapError(client.intercept(client.GetCtx(), op, func(ctx context.Context, op *Operation) error {
+		result, err := client.database.Collection(op.Collection).UpdateMany(ctx, op.Filter, op.Update)
+		op.Result = result
+		return err
+	}))
 }
 
 /*
@@ -159,25 +208,23 @@ func (client *MongoClient) UpdateMany(tableName string, filter bson.M, setter in
 通过条件查询一个文档
 */
 func (client *MongoClient) FindOne(tableName string, filter bson.M, table interface{}) error {
-	result := client.database.Collection(tableName).FindOne(client.GetCtx(), filter)
-	if result.Err() != nil {
-		return result.Err()
-	}
-	err := result.Decode(table)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	op := &Operation{Collection: tableName, Name: OpFindOne, Filter: filter, Result: table}
+	return wrapError(client.intercept(client.GetCtx(), op, func(ctx context.Context, op *Operation) error {
+		return client.database.Collection(op.Collection).FindOne(ctx, op.Filter).Decode(op.Result)
+	}))
 }
 
 func (client *MongoClient) FindCount(tableName string, filter bson.M) (int64, error) {
-	return client.database.Collection(tableName).CountDocuments(client.GetCtx(), filter)
+	return client.GetCountByCondition(tableName, filter)
 }
 
 func (client *MongoClient) Delete(tableName string, filter bson.M) error {
-	_, err := client.database.Collection(tableName).DeleteOne(client.GetCtx(), filter)
-	return err
+	op := &Operation{Collection: tableName, Name: OpDeleteOne, Filter: filter}
+	return wrapError(client.intercept(client.GetCtx(), op, func(ctx context.Context, op *Operation) error {
+		result, err := client.database.Collection(op.Collection).DeleteOne(ctx, op.Filter)
+		op.Result = result
+		return err
+	}))
 }
 
 func (client *MongoClient) GetRaw() *mongo.Database {
@@ -185,11 +232,18 @@ func (client *MongoClient) GetRaw() *mongo.Database {
 }
 
 func (client *MongoClient) Status() (string, error) {
-	bson, err := client.database.RunCommand(context.Background(), bson.M{"serverStatus": 1}).DecodeBytes()
+	command := bson.M{"serverStatus": 1}
+	op := &Operation{Name: OpCommand, Document: command}
+	err := client.intercept(context.Background(), op, func(ctx context.Context, op *Operation) error {
+		raw, err := client.database.RunCommand(ctx, op.Document).DecodeBytes()
+		op.Result = raw
+		return err
+	})
 	if err != nil {
-		return "", err
+		return "", wrapError(err)
 	}
-	return bson.String(), nil
+	raw, _ := op.Result.(bson.Raw)
+	return raw.String(), nil
 }
 
 /*
@@ -197,7 +251,32 @@ func (client *MongoClient) Status() (string, error) {
 通过条件查询列表
 */
 func (client *MongoClient) FindAllByCondition(tableName string, filter bson.M, options *options.FindOptions) (*mongo.Cursor, error) {
-	return client.database.Collection(tableName).Find(client.GetCtx(), filter, options)
+	op := &Operation{Collection: tableName, Name: OpFind, Filter: filter, Options: options}
+	err := client.intercept(client.GetCtx(), op, func(ctx context.Context, op *Operation) error {
+		cursor, err := client.database.Collection(op.Collection).Find(ctx, op.Filter, findOptions(op))
+		op.Result = cursor
+		return err
+	})
+	cursor, _ := op.Result.(*mongo.Cursor)
+	return cursor, wrapError(err)
+}
+
+// findAll 查询并解码到 result,拦截器看到的是包含解码的一次操作
+func (client *MongoClient) findAll(tableName string, filter interface{}, findOption *options.FindOptions, result interface{}) error {
+	op := &Operation{Collection: tableName, Name: OpFind, Filter: filter, Options: findOption, Result: result}
+	return wrapError(client.intercept(client.GetCtx(), op, func(ctx context.Context, op *Operation) error {
+		cursor, err := client.database.Collection(op.Collection).Find(ctx, op.Filter, findOptions(op))
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, op.Result)
+	}))
+}
+
+func findOptions(op *Operation) *options.FindOptions {
+	findOption, _ := op.Options.(*options.FindOptions)
+	return findOption
 }
 
 func (client *MongoClient) FindAll(tableName string, options *options.FindOptions) (*mongo.Cursor, error) {
@@ -205,8 +284,19 @@ func (client *MongoClient) FindAll(tableName string, options *options.FindOption
 	//return client.database.Collection(tableName).Find(client.GetCtx(),nil)
 }
 
+// withContext 返回绑定 ctx 的副本,GetCtx 以该 ctx 为父 context,事务中的 txCtx 可借此加入同一会话
+func (client *MongoClient) withContext(ctx context.Context) *MongoClient {
+	c := *client
+	c.ctx = ctx
+	return &c
+}
+
 func (client *MongoClient) GetCtx() context.Context {
-	ctx, _ := context.WithTimeout(context.Background(), client.duration)
+	parent := client.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, _ := context.WithTimeout(parent, client.duration)
 	return ctx
 }
 
@@ -215,17 +305,31 @@ func (client *MongoClient) GetDuration() time.Duration {
 }
 
 func (client *MongoClient) GetCountByCondition(tableName string, filter bson.M) (int64, error) {
-	return client.database.Collection(tableName).CountDocuments(client.GetCtx(), filter)
+	var count int64
+	op := &Operation{Collection: tableName, Name: OpCount, Filter: filter, Result: &count}
+	err := client.intercept(client.GetCtx(), op, func(ctx context.Context, op *Operation) error {
+		result, err := client.database.Collection(op.Collection).CountDocuments(ctx, op.Filter)
+		if target, ok := op.Result.(*int64); ok {
+			*target = result
+		}
+		return err
+	})
+	return count, wrapError(err)
 }
 
 func (client *MongoClient) GetByAggregate(tableName string, pipeline mongo.Pipeline) ([]bson.M, error) {
-	cursor, err := client.database.Collection(tableName).Aggregate(client.GetCtx(), pipeline)
-	if err != nil {
-		return nil, err
-	}
 	var results []bson.M
-	if err = cursor.All(client.GetCtx(), &results); err != nil {
-		return nil, err
+	op := &Operation{Collection: tableName, Name: OpAggregate, Pipeline: pipeline, Result: &results}
+	err := client.intercept(client.GetCtx(), op, func(ctx context.Context, op *Operation) error {
+		cursor, err := client.database.Collection(op.Collection).Aggregate(ctx, op.Pipeline)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, op.Result)
+	})
+	if err != nil {
+		return nil, wrapError(err)
 	}
 	return results, nil
 }
@@ -1,5 +1,10 @@
 package mongokits
 
+import (
+	"go.mongodb.org/mongo-driver/event"
+	"time"
+)
+
 type MongoOptions struct {
 	Id       string
 	server   string
@@ -7,6 +12,16 @@ type MongoOptions struct {
 	timeout  int
 	userName string
 	userPass string
+	// interceptors 仅作用于该数据源的拦截器
+	interceptors []Interceptor
+	logger       Logger
+	// sensitiveFields 日志中需要隐藏值的条件字段,内置字段之外的补充
+	sensitiveFields []string
+	commandMonitors []*event.CommandMonitor
+	poolMonitors    []*event.PoolMonitor
+	slowThreshold   time.Duration
+	slowCapacity    int
+	slowSink        SlowQuerySink
 }
 
 func (options *MongoOptions) Name(name string) *MongoOptions {
@@ -38,3 +53,51 @@ func (options *MongoOptions) TimeOut(timeout int) *MongoOptions {
 	options.timeout = timeout
 	return options
 }
+
+// Interceptors 注册仅作用于该数据源的拦截器,在全局拦截器之内执行
+func (options *MongoOptions) Interceptors(interceptors ...Interceptor) *MongoOptions {
+	options.interceptors = append(options.interceptors, interceptors...)
+	return options
+}
+
+// Logger 设置该数据源的日志,未设置时使用 SetLogger 设置的全局日志
+func (options *MongoOptions) Logger(logger Logger) *MongoOptions {
+	options.logger = logger
+	return options
+}
+
+// SensitiveFields 日志中需要隐藏值的条件字段,password、token 等已内置
+func (options *MongoOptions) SensitiveFields(fields ...string) *MongoOptions {
+	options.sensitiveFields = append(options.sensitiveFields, fields...)
+	return options
+}
+
+// CommandMonitor 追加驱动命令监听器,需在创建客户端前设置,OpenTelemetry 追踪与指标见 otelmongokits
+func (options *MongoOptions) CommandMonitor(monitor *event.CommandMonitor) *MongoOptions {
+	options.commandMonitors = append(options.commandMonitors, monitor)
+	return options
+}
+
+// PoolMonitor 追加连接池监听器,需在创建客户端前设置
+func (options *MongoOptions) PoolMonitor(monitor *event.PoolMonitor) *MongoOptions {
+	options.poolMonitors = append(options.poolMonitors, monitor)
+	return options
+}
+
+// SlowThreshold 耗时超过 threshold 的操作记录为慢操作,0 表示不记录,需在创建客户端前设置
+func (options *MongoOptions) SlowThreshold(threshold time.Duration) *MongoOptions {
+	options.slowThreshold = threshold
+	return options
+}
+
+// SlowQueryCapacity 保留最近慢操作的数量,默认 100
+func (options *MongoOptions) SlowQueryCapacity(capacity int) *MongoOptions {
+	options.slowCapacity = capacity
+	return options
+}
+
+// SlowQuerySink 接收慢操作,未设置时以 WARN 级别输出到数据源日志
+func (options *MongoOptions) SlowQuerySink(sink SlowQuerySink) *MongoOptions {
+	options.slowSink = sink
+	return options
+}
@@ -1,6 +1,7 @@
 package mongokits
 
 import (
+	"context"
 	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -27,6 +28,13 @@ type MongodbDatabase struct {
 	options *MongoOptions
 }
 
+// WithContext 返回绑定 ctx 的副本,后续操作以 ctx 为父 context,传入 WithTransaction 的 txCtx 时操作加入该事务
+func (i *MongodbDatabase) WithContext(ctx context.Context) *MongodbDatabase {
+	d := *i
+	d.client = i.client.withContext(ctx)
+	return &d
+}
+
 func (i *MongodbDatabase) Status() (string, error) {
 	return i.client.Status()
 }
@@ -50,26 +58,12 @@ func (i *MongodbDatabase) Update(table Table, condition interface{}, handlers ..
 }
 
 func (i *MongodbDatabase) Query(tableName string, condition interface{}, result interface{}) error {
-	cur, err := i.client.FindAllByCondition(tableName, condition.(bson.M), &options.FindOptions{})
-	if nil != err {
-		return err
-	}
-
-	ctx := i.client.GetCtx()
-	defer cur.Close(ctx)
-	return cur.All(i.client.GetCtx(), result)
+	return i.client.findAll(tableName, condition.(bson.M), &options.FindOptions{}, result)
 	//return mongo.client.FindAllByCondition(tableName,condition.(bson.M),&options.FindOptions{})
 }
 
 func (i *MongodbDatabase) QueryAll(tableName string, result interface{}) error {
-	cur, err := i.client.FindAll(tableName, &options.FindOptions{})
-	if nil != err {
-		return err
-	}
-
-	ctx := i.client.GetCtx()
-	defer cur.Close(ctx)
-	return cur.All(i.client.GetCtx(), result)
+	return i.client.findAll(tableName, bson.M{}, &options.FindOptions{}, result)
 }
 
 func (i *MongodbDatabase) QueryOne(tableName string, condition interface{}, result interface{}) error {
@@ -77,7 +71,7 @@ func (i *MongodbDatabase) QueryOne(tableName string, condition interface{}, resu
 }
 
 func (i *MongodbDatabase) QueryByDocumentId(tableName string, docId string, result interface{}) error {
-	objectId, err := primitive.ObjectIDFromHex(docId)
+	objectId, err := parseObjectId(docId)
 	if err != nil {
 		return err
 	}
@@ -85,15 +79,33 @@ func (i *MongodbDatabase) QueryByDocumentId(tableName string, docId string, resu
 }
 
 func (i *MongodbDatabase) QueryAllByCondition(tableName string, condition interface{}, findOption *options.FindOptions, result interface{}) error {
-	cur, err := i.client.FindAllByCondition(tableName, condition.(bson.M), findOption)
-	if nil != err {
-		return err
-	}
-	ctx := i.client.GetCtx()
-	defer cur.Close(ctx)
-	return cur.All(i.client.GetCtx(), result)
+	return i.client.findAll(tableName, condition.(bson.M), findOption, result)
 }
 
 func (i *MongodbDatabase) GetCountByCondition(tableName string, condition interface{}) (int64, error) {
 	return i.client.GetCountByCondition(tableName, condition.(bson.M))
 }
+
+func (i *MongodbDatabase) Aggregate(tableName string, pipeline mongo.Pipeline) ([]bson.M, error) {
+	return i.client.GetByAggregate(tableName, pipeline)
+}
+
+func parseObjectId(id string) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return oid, newKindError(ErrorInvalidId, err)
+	}
+	return oid, nil
+}
+
+func parseObjectIds(ids []string) ([]primitive.ObjectID, error) {
+	var oids []primitive.ObjectID
+	for _, id := range ids {
+		oid, err := parseObjectId(id)
+		if err != nil {
+			return nil, err
+		}
+		oids = append(oids, oid)
+	}
+	return oids, nil
+}
@@ -0,0 +1,314 @@
+package mongokits
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+type BucketPeriod int
+
+const (
+	BucketByDay BucketPeriod = iota
+	BucketByMonth
+	BucketByYear
+)
+
+func (p BucketPeriod) layout() string {
+	switch p {
+	case BucketByDay:
+		return "2006_01_02"
+	case BucketByYear:
+		return "2006"
+	default:
+		return "2006_01"
+	}
+}
+
+// pattern 集合名后缀的正则,与 layout 一一对应
+func (p BucketPeriod) pattern() string {
+	switch p {
+	case BucketByDay:
+		return `\d{4}_\d{2}_\d{2}`
+	case BucketByYear:
+		return `\d{4}`
+	default:
+		return `\d{4}_\d{2}`
+	}
+}
+
+// next 周期的结束时间(下一个周期的起始时间)
+func (p BucketPeriod) next(start time.Time) time.Time {
+	switch p {
+	case BucketByDay:
+		return start.AddDate(0, 0, 1)
+	case BucketByYear:
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+/*
+*
+Bucketed Table 实现该接口后按时间分集合存储,集合名为 TableName()_周期,如 events_2026_10(按月,UTC)
+BucketField 返回时间字段名(bson 名称),BucketTime 返回文档所属的时间
+*/
+type Bucketed interface {
+	BucketField() string
+	BucketPeriod() BucketPeriod
+	BucketTime() time.Time
+}
+
+type bucket struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
+// MongodbBucketed 按时间分集合的仓库,写入按文档时间路由,按时间范围查询时只访问相关的集合
+type MongodbBucketed[T Table] struct {
+	database *MongodbDatabase
+	ctx      context.Context
+}
+
+func GetBucketedDatabase[T Table]() (*MongodbBucketed[T], error) {
+	var r T
+	db, err := tableDatabase(context.TODO(), r, &r)
+	if nil != err {
+		return nil, err
+	}
+	return newBucketed[T](db)
+}
+
+func GetBucketedDatabaseById[T Table](dbId string) (*MongodbBucketed[T], error) {
+	db, err := GetDefaultManager().GetDatabaseById(dbId)
+	if nil != err {
+		return nil, err
+	}
+	return newBucketed[T](db)
+}
+
+func newBucketed[T Table](db *MongodbDatabase) (*MongodbBucketed[T], error) {
+	var r T
+	if !hasHook[T, Bucketed]() {
+		return nil, fmt.Errorf("table %s does not implement Bucketed", r.TableName())
+	}
+	return &MongodbBucketed[T]{
+		database: db,
+	}, nil
+}
+
+func (i *MongodbBucketed[T]) WithContext(ctx context.Context) *MongodbBucketed[T] {
+	g := *i
+	g.ctx = ctx
+	g.database = i.database.WithContext(ctx)
+	return &g
+}
+
+func (i *MongodbBucketed[T]) getCtx() context.Context {
+	if i.ctx == nil {
+		return context.TODO()
+	}
+	return i.ctx
+}
+
+func (i *MongodbBucketed[T]) GetRaw() *mongo.Database {
+	return i.database.GetRaw()
+}
+
+func (i *MongodbBucketed[T]) bucketed() Bucketed {
+	var r T
+	b, _ := lookupHook[Bucketed](r, &r)
+	return b
+}
+
+// BucketName 时间 t 所属的集合名
+func (i *MongodbBucketed[T]) BucketName(t time.Time) string {
+	var r T
+	return r.TableName() + "_" + t.UTC().Format(i.bucketed().BucketPeriod().layout())
+}
+
+func (i *MongodbBucketed[T]) docBucket(doc T) (string, error) {
+	b, _ := lookupHook[Bucketed](doc, &doc)
+	t := b.BucketTime()
+	if t.IsZero() {
+		return "", ErrorBucketTimeRequired
+	}
+	return i.BucketName(t), nil
+}
+
+func (i *MongodbBucketed[T]) Insert(doc T) (string, error) {
+	docs := []T{doc}
+	if err := prepareInsertAll(i.getCtx(), docs); err != nil {
+		return "", err
+	}
+	name, err := i.docBucket(docs[0])
+	if err != nil {
+		return "", err
+	}
+	result, err := i.database.insertOne(i.getCtx(), name, docs[0])
+	if err != nil {
+		return "", wrapError(err)
+	}
+	switch id := result.InsertedID.(type) {
+	case primitive.ObjectID:
+		return id.Hex(), nil
+	case string:
+		return id, nil
+	}
+	return "", nil
+}
+
+// InsertMany 按文档时间分组写入各集合,钩子与时间戳在分组前执行
+func (i *MongodbBucketed[T]) InsertMany(docs []T, ops ...*InsertManyOptions) (*InsertManyResult[string], error) {
+	if err := prepareInsertAll(i.getCtx(), docs); err != nil {
+		return nil, err
+	}
+	return insertGroups(docs, i.docBucket, func(name string, part []T) (*InsertManyResult[string], error) {
+		return insertManyInto[T, string](i.getCtx(), i.database, name, part, ops...)
+	})
+}
+
+// Buckets 已存在的集合,按时间升序
+func (i *MongodbBucketed[T]) Buckets() ([]string, error) {
+	buckets, err := i.buckets()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(buckets))
+	for index, b := range buckets {
+		names[index] = b.name
+	}
+	return names, nil
+}
+
+// buckets 已存在的集合,只包含后缀完全符合周期格式的集合,同名前缀的其他集合(如 events_2024_01_backup)不会被查询或删除
+func (i *MongodbBucketed[T]) buckets() ([]bucket, error) {
+	var r T
+	period := i.bucketed().BucketPeriod()
+	prefix := r.TableName() + "_"
+	names, err := i.database.listCollectionNames(i.getCtx(),
+		bson.M{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(prefix) + period.pattern() + "$"}})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	var result []bucket
+	for _, name := range names {
+		if b, ok := parseBucket(prefix, period, name); ok {
+			result = append(result, b)
+		}
+	}
+	sort.Slice(result, func(x, y int) bool {
+		return result[x].start.Before(result[y].start)
+	})
+	return result, nil
+}
+
+// parseBucket 集合名需为 prefix 加周期起始时间按 layout 格式化的结果
+func parseBucket(prefix string, period BucketPeriod, name string) (bucket, bool) {
+	suffix := strings.TrimPrefix(name, prefix)
+	if suffix == name {
+		return bucket{}, false
+	}
+	start, err := time.ParseInLocation(period.layout(), suffix, time.UTC)
+	if err != nil || start.Format(period.layout()) != suffix {
+		return bucket{}, false
+	}
+	return bucket{name: name, start: start, end: period.next(start)}, true
+}
+
+// rangeBuckets 与 [from, to) 有交集的已存在集合,零值表示不限
+func (i *MongodbBucketed[T]) rangeBuckets(from, to time.Time) ([]bucket, error) {
+	buckets, err := i.buckets()
+	if err != nil {
+		return nil, err
+	}
+	var result []bucket
+	for _, b := range buckets {
+		if (!to.IsZero() && !b.start.Before(to)) || (!from.IsZero() && !b.end.After(from)) {
+			continue
+		}
+		result = append(result, b)
+	}
+	return result, nil
+}
+
+func (i *MongodbBucketed[T]) rangeFilter(from, to time.Time, cond bson.M) interface{} {
+	timeRange := bson.M{}
+	if !from.IsZero() {
+		timeRange["$gte"] = from
+	}
+	if !to.IsZero() {
+		timeRange["$lt"] = to
+	}
+	if len(timeRange) == 0 {
+		return cond
+	}
+	return mergeFilter(cond, i.bucketed().BucketField(), timeRange)
+}
+
+// QueryRange 查询 [from, to) 内的文档,只访问相关的集合,op 的排序、跳过与数量作用于合并后的结果
+func (i *MongodbBucketed[T]) QueryRange(from, to time.Time, cond bson.M, op *options.FindOptions) ([]T, error) {
+	buckets, err := i.rangeBuckets(from, to)
+	if err != nil {
+		return nil, err
+	}
+	filter := i.rangeFilter(from, to, cond)
+	queries := make([]func(op *options.FindOptions) ([]T, error), len(buckets))
+	for index, b := range buckets {
+		name := b.name
+		queries[index] = func(op *options.FindOptions) ([]T, error) {
+			return queryCollection[T](i.getCtx(), i.database, name, scopeExcludeDeleted, filter, op)
+		}
+	}
+	return fanOutQuery(queries, op, !inTransaction(i.ctx, i.database.GetRaw().Client()))
+}
+
+func (i *MongodbBucketed[T]) CountRange(from, to time.Time, cond bson.M) (int64, error) {
+	buckets, err := i.rangeBuckets(from, to)
+	if err != nil {
+		return 0, err
+	}
+	filter, _ := i.rangeFilter(from, to, cond).(bson.M)
+	var total int64
+	for _, b := range buckets {
+		count, err := countCollection[T](i.getCtx(), i.database, b.name, scopeExcludeDeleted, filter)
+		if err != nil {
+			return total, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// DropBefore 删除周期结束时间不晚于 t 的整个集合,返回已删除的集合名
+func (i *MongodbBucketed[T]) DropBefore(t time.Time) ([]string, error) {
+	buckets, err := i.buckets()
+	if err != nil {
+		return nil, err
+	}
+	var dropped []string
+	for _, b := range buckets {
+		if b.end.After(t) {
+			break
+		}
+		if err := i.database.dropCollection(i.getCtx(), b.name); err != nil {
+			return dropped, wrapError(err)
+		}
+		dropped = append(dropped, b.name)
+	}
+	return dropped, nil
+}
+
+// Retain 保留最近 retention 内的集合,删除更早的集合
+func (i *MongodbBucketed[T]) Retain(retention time.Duration) ([]string, error) {
+	return i.DropBefore(time.Now().Add(-retention))
+}
@@ -0,0 +1,84 @@
+package mongokits
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type bucketedEvent struct {
+	Id primitive.ObjectID `bson:"_id"`
+	At time.Time          `bson:"at"`
+}
+
+func (e *bucketedEvent) TableName() string          { return "test_bucket_events" }
+func (e *bucketedEvent) PrimaryKey() interface{}    { return e.Id }
+func (e *bucketedEvent) PrimaryKeyName() string     { return "_id" }
+func (e *bucketedEvent) BucketField() string        { return "at" }
+func (e *bucketedEvent) BucketPeriod() BucketPeriod { return BucketByMonth }
+func (e *bucketedEvent) BucketTime() time.Time      { return e.At }
+
+func TestParseBucket(t *testing.T) {
+	prefix := "events_"
+	for _, c := range []struct {
+		period BucketPeriod
+		name   string
+		ok     bool
+	}{
+		{BucketByMonth, "events_2024_03", true},
+		{BucketByMonth, "events_2024_03_backup", false},
+		{BucketByMonth, "events_2024", false},
+		{BucketByMonth, "events_2024_13", false},
+		{BucketByMonth, "events_archive_2024_03", false},
+		{BucketByDay, "events_2024_02_29", true},
+		{BucketByDay, "events_2023_02_29", false},
+		{BucketByYear, "events_2024", true},
+		{BucketByYear, "events_2024_03", false},
+		{BucketByYear, "other_2024", false},
+	} {
+		if _, ok := parseBucket(prefix, c.period, c.name); ok != c.ok {
+			t.Errorf("parseBucket(%s) = %v, want %v", c.name, ok, c.ok)
+		}
+	}
+}
+
+// DropBefore 只删除符合周期格式的集合,同前缀的其他集合保留
+func TestDropBeforeKeepsUnrelatedCollections(t *testing.T) {
+	unrelated := []string{"test_bucket_events_2024_01_backup", "test_bucket_events_2024", "test_bucket_events_archive"}
+	buckets := []string{"test_bucket_events_2024_01", "test_bucket_events_2024_02", "test_bucket_events_2099_01"}
+	database := openTestDatabase(t, append(append([]string{}, unrelated...), buckets...)...)
+	ctx := context.Background()
+	for _, name := range append(append([]string{}, unrelated...), buckets...) {
+		if _, err := database.GetRaw().Collection(name).InsertOne(ctx, bson.M{"at": time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo, err := GetBucketedDatabase[*bucketedEvent]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped, err := repo.DropBefore(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 2 || dropped[0] != buckets[0] || dropped[1] != buckets[1] {
+		t.Fatalf("unexpected dropped collections %v", dropped)
+	}
+	names, err := database.GetRaw().ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := make(map[string]bool)
+	for _, name := range names {
+		existing[name] = true
+	}
+	for _, name := range append(unrelated, buckets[2]) {
+		if !existing[name] {
+			t.Errorf("collection %s should survive", name)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package mongokits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"time"
+)
+
+const (
+	// 服务端单批次最多写入的操作数量(maxWriteBatchSize)
+	defaultBulkBatchCount = 100000
+	// 服务端单条消息上限为48MB,单个文档上限为16MB,按16MB切分并预留命令开销
+	defaultBulkBatchBytes = 16*1024*1024 - 16*1024
+)
+
+type BulkOperationType int
+
+const (
+	BulkInsertOne BulkOperationType = iota
+	BulkUpdateOne
+	BulkUpdateMany
+	BulkReplaceOne
+	BulkDeleteOne
+	BulkDeleteMany
+)
+
+func (t BulkOperationType) String() string {
+	switch t {
+	case BulkInsertOne:
+		return "InsertOne"
+	case BulkUpdateOne:
+		return "UpdateOne"
+	case BulkUpdateMany:
+		return "UpdateMany"
+	case BulkReplaceOne:
+		return "ReplaceOne"
+	case BulkDeleteOne:
+		return "DeleteOne"
+	case BulkDeleteMany:
+		return "DeleteMany"
+	}
+	return "Unknown"
+}
+
+// BulkItemResult 单个操作的执行结果,Index 为该操作在添加顺序中的下标
+type BulkItemResult struct {
+	Index      int
+	Type       BulkOperationType
+	UpsertedId interface{}
+	Err        error
+}
+
+func (r BulkItemResult) Succeeded() bool {
+	return r.Err == nil
+}
+
+type BulkResult struct {
+	InsertedCount int64
+	MatchedCount  int64
+	ModifiedCount int64
+	DeletedCount  int64
+	UpsertedCount int64
+	Items         []BulkItemResult
+}
+
+// Failed 返回执行失败(包括未执行)的操作
+func (r *BulkResult) Failed() []BulkItemResult {
+	var failed []BulkItemResult
+	for _, item := range r.Items {
+		if item.Err != nil {
+			failed = append(failed, item)
+		}
+	}
+	return failed
+}
+
+// BulkError 批量写入部分失败,Failed 为失败的操作,errors.Is/As 匹配第一个失败原因
+type BulkError struct {
+	Total  int
+	Failed []BulkItemResult
+}
+
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("bulk write failure,%d/%d: %v", len(e.Failed), e.Total, e.Unwrap())
+}
+
+func (e *BulkError) Unwrap() error {
+	for _, item := range e.Failed {
+		if item.Err != ErrorBulkNotExecuted {
+			return item.Err
+		}
+	}
+	return ErrorBulkNotExecuted
+}
+
+type bulkOperation[T Table] struct {
+	opType BulkOperationType
+	filter interface{}
+	update interface{}
+	doc    T
+	upsert bool
+}
+
+// 驱动按命令类型合并批次,ReplaceOne 与 UpdateOne 使用同一个 update 命令
+func (op *bulkOperation[T]) kind() BulkOperationType {
+	if op.opType == BulkReplaceOne {
+		return BulkUpdateOne
+	}
+	return op.opType
+}
+
+func (op *bulkOperation[T]) model() mongo.WriteModel {
+	switch op.opType {
+	case BulkInsertOne:
+		return mongo.NewInsertOneModel().SetDocument(op.doc)
+	case BulkUpdateOne:
+		return mongo.NewUpdateOneModel().SetFilter(op.filter).SetUpdate(op.update).SetUpsert(op.upsert)
+	case BulkUpdateMany:
+		return mongo.NewUpdateManyModel().SetFilter(op.filter).SetUpdate(op.update).SetUpsert(op.upsert)
+	case BulkReplaceOne:
+		return mongo.NewReplaceOneModel().SetFilter(op.filter).SetReplacement(op.doc).SetUpsert(op.upsert)
+	case BulkDeleteOne:
+		return mongo.NewDeleteOneModel().SetFilter(op.filter)
+	default:
+		return mongo.NewDeleteManyModel().SetFilter(op.filter)
+	}
+}
+
+func (op *bulkOperation[T]) size() int {
+	size := bsonSize(op.filter)
+	switch op.opType {
+	case BulkInsertOne, BulkReplaceOne:
+		size += bsonSize(op.doc)
+	case BulkUpdateOne, BulkUpdateMany:
+		size += bsonSize(op.update)
+	}
+	return size
+}
+
+type bulkBatch struct {
+	indexes []int
+	models  []mongo.WriteModel
+	size    int
+}
+
+type Bulk[T Table] struct {
+	database   *MongodbDatabase
+	ordered    bool
+	batchCount int
+	batchBytes int
+	operations []*bulkOperation[T]
+	// afterExecute 执行后调用,用于失效仓库缓存、记录写入时间
+	afterExecute func()
+}
+
+func NewBulk[T Table](database *MongodbDatabase) *Bulk[T] {
+	return &Bulk[T]{
+		database:   database,
+		ordered:    true,
+		batchCount: defaultBulkBatchCount,
+		batchBytes: defaultBulkBatchBytes,
+	}
+}
+
+func (i *MongodbGeneric[T]) Bulk() *Bulk[T] {
+	bulk := NewBulk[T](i.database)
+	if i.cache != nil {
+		bulk.afterExecute = i.invalidateAll
+	}
+	return bulk
+}
+
+func (i *MongodbGenericComplex[T]) Bulk() *Bulk[T] {
+	bulk := NewBulk[T](i.writer)
+	bulk.afterExecute = i.markWrite
+	return bulk
+}
+
+// Ordered 有序执行时遇到失败即停止,后续操作标记为未执行;无序执行时所有操作都会尝试
+func (b *Bulk[T]) Ordered(ordered bool) *Bulk[T] {
+	b.ordered = ordered
+	return b
+}
+
+func (b *Bulk[T]) BatchCount(count int) *Bulk[T] {
+	if count > 0 && count <= defaultBulkBatchCount {
+		b.batchCount = count
+	}
+	return b
+}
+
+func (b *Bulk[T]) BatchBytes(size int) *Bulk[T] {
+	if size > 0 && size <= defaultBulkBatchBytes {
+		b.batchBytes = size
+	}
+	return b
+}
+
+func (b *Bulk[T]) Len() int {
+	return len(b.operations)
+}
+
+func (b *Bulk[T]) InsertOne(doc T) *Bulk[T] {
+	return b.add(&bulkOperation[T]{opType: BulkInsertOne, doc: doc})
+}
+
+func (b *Bulk[T]) UpdateOne(filter bson.M, update interface{}, upsert bool) *Bulk[T] {
+	return b.add(&bulkOperation[T]{opType: BulkUpdateOne, filter: filter, update: update, upsert: upsert})
+}
+
+func (b *Bulk[T]) UpdateMany(filter bson.M, update interface{}, upsert bool) *Bulk[T] {
+	return b.add(&bulkOperation[T]{opType: BulkUpdateMany, filter: filter, update: update, upsert: upsert})
+}
+
+func (b *Bulk[T]) ReplaceOne(filter bson.M, doc T, upsert bool) *Bulk[T] {
+	return b.add(&bulkOperation[T]{opType: BulkReplaceOne, filter: filter, doc: doc, upsert: upsert})
+}
+
+func (b *Bulk[T]) DeleteOne(filter bson.M) *Bulk[T] {
+	return b.add(&bulkOperation[T]{opType: BulkDeleteOne, filter: filter})
+}
+
+func (b *Bulk[T]) DeleteMany(filter bson.M) *Bulk[T] {
+	return b.add(&bulkOperation[T]{opType: BulkDeleteMany, filter: filter})
+}
+
+func (b *Bulk[T]) add(op *bulkOperation[T]) *Bulk[T] {
+	b.operations = append(b.operations, op)
+	return b
+}
+
+func bsonSize(doc interface{}) int {
+	switch v := doc.(type) {
+	case bson.A:
+		size := 0
+		for _, item := range v {
+			size += bsonSize(item)
+		}
+		return size
+	case mongo.Pipeline:
+		size := 0
+		for _, stage := range v {
+			size += bsonSize(stage)
+		}
+		return size
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// batches 按命令类型、数量和大小切分批次,每个批次只包含一种命令,保证驱动返回的下标可以映射回原始操作
+func (b *Bulk[T]) batches(operations []*bulkOperation[T]) []*bulkBatch {
+	indexes := make([]int, len(operations))
+	for index := range operations {
+		indexes[index] = index
+	}
+	if !b.ordered {
+		// 无序执行时按类型首次出现的顺序归并,减少批次数量
+		rank := make(map[BulkOperationType]int)
+		for _, op := range operations {
+			if _, exists := rank[op.kind()]; !exists {
+				rank[op.kind()] = len(rank)
+			}
+		}
+		sort.SliceStable(indexes, func(x, y int) bool {
+			return rank[operations[indexes[x]].kind()] < rank[operations[indexes[y]].kind()]
+		})
+	}
+
+	var result []*bulkBatch
+	var current *bulkBatch
+	for _, index := range indexes {
+		op := operations[index]
+		size := op.size()
+		if current == nil ||
+			operations[current.indexes[0]].kind() != op.kind() ||
+			len(current.indexes) >= b.batchCount ||
+			current.size+size > b.batchBytes {
+			current = &bulkBatch{}
+			result = append(result, current)
+		}
+		current.indexes = append(current.indexes, index)
+		current.models = append(current.models, op.model())
+		current.size += size
+	}
+	return result
+}
+
+// Execute 执行全部操作,存在失败操作时返回 *BulkError,结果中包含每个操作的执行情况
+// 每次执行基于添加时的操作与文档的副本重新准备,调用方的文档不会被钩子修改,重复执行不会叠加租户条件与更新时间;SoftDeletable 的删除计入 ModifiedCount
+func (b *Bulk[T]) Execute(ctx context.Context) (*BulkResult, error) {
+	if len(b.operations) == 0 {
+		return nil, ErrorBulkEmpty
+	}
+	operations, err := b.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if b.afterExecute != nil {
+		defer b.afterExecute()
+	}
+	var r T
+	result := &BulkResult{Items: make([]BulkItemResult, len(b.operations))}
+	for index, op := range b.operations {
+		result.Items[index] = BulkItemResult{Index: index, Type: op.opType, Err: ErrorBulkNotExecuted}
+	}
+
+	failed := false
+	for _, batch := range b.batches(operations) {
+		if failed && b.ordered {
+			break
+		}
+		res, err := b.database.bulkWrite(ctx, r.TableName(), batch.models, options.BulkWrite().SetOrdered(b.ordered))
+		if res != nil {
+			result.InsertedCount += res.InsertedCount
+			result.MatchedCount += res.MatchedCount
+			result.ModifiedCount += res.ModifiedCount
+			result.DeletedCount += res.DeletedCount
+			result.UpsertedCount += res.UpsertedCount
+		}
+		if err != nil {
+			failed = true
+		}
+		b.applyBatchResult(result, batch, res, err)
+	}
+
+	if failed {
+		return result, &BulkError{Total: len(b.operations), Failed: result.Failed()}
+	}
+	return result, nil
+}
+
+/*
+*
+prepare 返回准备好的操作副本,添加的操作与文档保持不变:文档复制后(copyDocument)再调用钩子并填充租户与时间戳,按字段更新的语句注入更新时间,条件追加租户
+删除与 Delete 一致,先对待删除的文档调用 BeforeDelete,SoftDeletable 的删除转换为设置删除时间的更新
+*/
+func (b *Bulk[T]) prepare(ctx context.Context) ([]*bulkOperation[T], error) {
+	now := time.Now()
+	deletedAt, soft := softDeleteField[T]()
+	operations := make([]*bulkOperation[T], len(b.operations))
+	for index, added := range b.operations {
+		op := *added
+		op.doc = copyDocument(added.doc)
+		operations[index] = &op
+		if op.opType != BulkInsertOne {
+			filter, err := tenantFilter[T](ctx, op.filter)
+			if err != nil {
+				return nil, err
+			}
+			op.filter = filter
+		}
+		switch op.opType {
+		case BulkInsertOne:
+			if err := prepareInsert(ctx, op.doc, &op.doc); err != nil {
+				return nil, err
+			}
+		case BulkReplaceOne:
+			if err := prepareUpdate(ctx, op.doc, &op.doc); err != nil {
+				return nil, err
+			}
+		case BulkUpdateOne, BulkUpdateMany:
+			if err := tenantSetter[T](ctx, op.update); err != nil {
+				return nil, err
+			}
+			op.update = touchSetter[T](ctx, op.update, op.upsert)
+		case BulkDeleteOne, BulkDeleteMany:
+			findOption := options.Find()
+			if op.opType == BulkDeleteOne {
+				findOption.SetLimit(1)
+			}
+			if soft {
+				op.filter = mergeFilter(op.filter, deletedAt, nil)
+			}
+			if err := beforeDelete[T](ctx, b.database.WithContext(ctx), op.filter, findOption); err != nil {
+				return nil, err
+			}
+			if soft {
+				op.update = bson.M{"$set": bson.M{deletedAt: now}}
+				op.opType = BulkUpdateOne
+				if added.opType == BulkDeleteMany {
+					op.opType = BulkUpdateMany
+				}
+			}
+		}
+	}
+	return operations, nil
+}
+
+func (b *Bulk[T]) applyBatchResult(result *BulkResult, batch *bulkBatch, res *mongo.BulkWriteResult, err error) {
+	var bwe mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bwe) {
+		// 非写入错误(网络、超时等),整个批次视为失败
+		for _, index := range batch.indexes {
+			result.Items[index].Err = wrapError(err)
+		}
+		return
+	}
+
+	itemErrors := make(map[int]error)
+	for _, we := range bwe.WriteErrors {
+		if we.Index >= 0 && we.Index < len(batch.indexes) {
+			itemErrors[we.Index] = wrapError(we.WriteError)
+		}
+	}
+	firstFailure := len(batch.indexes)
+	for pos := range itemErrors {
+		if pos < firstFailure {
+			firstFailure = pos
+		}
+	}
+
+	for pos, index := range batch.indexes {
+		item := &result.Items[index]
+		switch {
+		case itemErrors[pos] != nil:
+			item.Err = itemErrors[pos]
+		case b.ordered && pos > firstFailure:
+			item.Err = ErrorBulkNotExecuted
+		case bwe.WriteConcernError != nil:
+			item.Err = wrapError(*bwe.WriteConcernError)
+		default:
+			item.Err = nil
+		}
+		if res != nil {
+			if id, exists := res.UpsertedIDs[int64(pos)]; exists {
+				item.UpsertedId = id
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package mongokits
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// softItem 软删除的表,删除前记录调用过 BeforeDelete 的文档
+type softItem struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	DeletedAt *time.Time         `bson:"deleted_at"`
+}
+
+var softItemDeleting []primitive.ObjectID
+
+func (s *softItem) TableName() string       { return "test_soft_items" }
+func (s *softItem) PrimaryKey() interface{} { return s.Id }
+func (s *softItem) PrimaryKeyName() string  { return "_id" }
+func (s *softItem) DeletedAtField() string  { return "deleted_at" }
+
+func (s *softItem) BeforeDelete(ctx context.Context) error {
+	softItemDeleting = append(softItemDeleting, s.Id)
+	return nil
+}
+
+// newBulkDatabase 记录每次 bulkWrite 的写入模型,查询返回 found
+func newBulkDatabase[T Table](t *testing.T, found []T) (*MongodbDatabase, *[][]mongo.WriteModel) {
+	var writes [][]mongo.WriteModel
+	database := newFakeDatabase(t, func(ctx context.Context, op *Operation, next Invoker) error {
+		switch op.Name {
+		case OpBulkWrite:
+			models := op.Document.([]mongo.WriteModel)
+			writes = append(writes, models)
+			op.Result = &mongo.BulkWriteResult{InsertedCount: int64(len(models)), MatchedCount: int64(len(models))}
+		case OpFind:
+			*op.Result.(*[]T) = append([]T(nil), found...)
+		}
+		return nil
+	})
+	return database, &writes
+}
+
+// 重复执行时每次都基于添加时的操作准备,租户条件与钩子不会叠加
+func TestBulkExecuteTwice(t *testing.T) {
+	database, writes := newBulkDatabase[*tenantNote](t, nil)
+	ctx := WithTenant(context.Background(), "a")
+	filter := bson.M{"text": "x"}
+	bulk := NewBulk[*tenantNote](database).UpdateOne(filter, bson.M{"$set": bson.M{"text": "y"}}, false)
+	for n := 0; n < 2; n++ {
+		if _, err := bulk.Execute(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := (*writes)[0][0].(*mongo.UpdateOneModel)
+	second := (*writes)[1][0].(*mongo.UpdateOneModel)
+	if !reflect.DeepEqual(first.Filter, second.Filter) || !reflect.DeepEqual(first.Filter, bson.M{"text": "x", "tenant_id": "a"}) {
+		t.Fatalf("filters differ between executions: %v, %v", first.Filter, second.Filter)
+	}
+	if len(filter) != 1 {
+		t.Fatalf("caller filter was modified: %v", filter)
+	}
+
+	database, writes = newBulkDatabase[*tenantNote](t, nil)
+	note := &tenantNote{Id: primitive.NewObjectID(), Text: "x"}
+	if _, err := NewBulk[*tenantNote](database).InsertOne(note).ReplaceOne(bson.M{"_id": note.Id}, note, false).Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if note.TenantId != "" {
+		t.Fatalf("caller document was modified: %+v", note)
+	}
+	inserted := (*writes)[0][0].(*mongo.InsertOneModel).Document.(*tenantNote)
+	replaced := (*writes)[1][0].(*mongo.ReplaceOneModel).Replacement.(*tenantNote)
+	if inserted == note || replaced == note || inserted.TenantId != "a" || replaced.TenantId != "a" {
+		t.Fatalf("tenant not stamped on copies: %+v, %+v", inserted, replaced)
+	}
+
+	database, writes = newBulkDatabase[stampedItem](t, nil)
+	values := NewBulk[stampedItem](database).InsertOne(stampedItem{Name: "Value"})
+	for n := 0; n < 2; n++ {
+		if _, err := values.Execute(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if values.operations[0].doc.Slug != "" || !values.operations[0].doc.CreatedAt.IsZero() {
+		t.Fatalf("added document was modified: %+v", values.operations[0].doc)
+	}
+	for _, models := range *writes {
+		doc := models[0].(*mongo.InsertOneModel).Document.(stampedItem)
+		if doc.Slug != "value" || doc.CreatedAt.IsZero() {
+			t.Fatalf("hooks not applied to the written document: %+v", doc)
+		}
+	}
+}
+
+// 软删除的表批量删除时与 Delete 一致:先调用 BeforeDelete,再设置删除时间
+func TestBulkSoftDelete(t *testing.T) {
+	found := []*softItem{{Id: primitive.NewObjectID()}, {Id: primitive.NewObjectID()}}
+	database, writes := newBulkDatabase(t, found)
+	softItemDeleting = nil
+	result, err := NewBulk[*softItem](database).DeleteMany(bson.M{"kind": "old"}).Execute(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(softItemDeleting) != len(found) {
+		t.Fatalf("BeforeDelete called for %v", softItemDeleting)
+	}
+	model, ok := (*writes)[0][0].(*mongo.UpdateManyModel)
+	if !ok {
+		t.Fatalf("soft delete should be written as an update, got %T", (*writes)[0][0])
+	}
+	if !reflect.DeepEqual(model.Filter, bson.M{"kind": "old", "deleted_at": nil}) {
+		t.Fatalf("unexpected filter %v", model.Filter)
+	}
+	if _, ok := model.Update.(bson.M)["$set"].(bson.M)["deleted_at"].(time.Time); !ok {
+		t.Fatalf("unexpected update %v", model.Update)
+	}
+	if result.Items[0].Type != BulkDeleteMany {
+		t.Fatalf("result should keep the added type, got %v", result.Items[0].Type)
+	}
+}
@@ -0,0 +1,332 @@
+package mongokits
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache 缓存后端,值为文档的 bson 编码,可使用 Redis 等实现
+// DeletePrefix 删除以 prefix 开头的所有键,用于按集合失效
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+	Delete(keys ...string)
+	DeletePrefix(prefix string)
+}
+
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// HitRate 命中率,没有请求时为 0
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type memoryEntry struct {
+	key      string
+	value    []byte
+	expireAt time.Time
+}
+
+// MemoryCache 进程内 LRU 缓存,超过容量时淘汰最久未使用的条目,条目过期后在读取时删除
+type MemoryCache struct {
+	mutex     sync.Mutex
+	capacity  int
+	items     map[string]*list.Element
+	lru       *list.List
+	evictions int64
+}
+
+func NewMemoryCache(capacity int) *MemoryCache {
+	return &MemoryCache{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*memoryEntry)
+	if !entry.expireAt.IsZero() && time.Now().After(entry.expireAt) {
+		c.remove(element)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.value, true
+}
+
+// Set ttl 小于等于 0 时不过期
+func (c *MemoryCache) Set(key string, value []byte, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	if element, ok := c.items[key]; ok {
+		entry := element.Value.(*memoryEntry)
+		entry.value = value
+		entry.expireAt = expireAt
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.items[key] = c.lru.PushFront(&memoryEntry{key: key, value: value, expireAt: expireAt})
+	for c.capacity > 0 && c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+func (c *MemoryCache) Delete(keys ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, key := range keys {
+		if element, ok := c.items[key]; ok {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *MemoryCache) DeletePrefix(prefix string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, element := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *MemoryCache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.items, element.Value.(*memoryEntry).key)
+}
+
+func (c *MemoryCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+// Evictions 因超过容量被淘汰的条目数量
+func (c *MemoryCache) Evictions() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.evictions
+}
+
+// repoCache 仓库的缓存配置与统计,仓库副本(WithContext 等)共享同一个 repoCache
+type repoCache struct {
+	cache  Cache
+	ttl    time.Duration
+	hits   int64
+	misses int64
+}
+
+// WithCache 返回启用读缓存的副本:GetById 与 GetByCondCached 先读缓存,写操作后自动失效
+// 事务中的读取绕过缓存,事务中的写入在提交前失效,提交前被并发读取的旧值最多保留 ttl
+// 多实例部署使用进程内缓存时,其他实例的写入在 ttl 内可能读到旧数据
+func (i *MongodbGeneric[T]) WithCache(cache Cache, ttl time.Duration) *MongodbGeneric[T] {
+	g := *i
+	g.cache = &repoCache{cache: cache, ttl: ttl}
+	return &g
+}
+
+func (i *MongodbGeneric[T]) CacheStats() CacheStats {
+	if i.cache == nil {
+		return CacheStats{}
+	}
+	return CacheStats{Hits: atomic.LoadInt64(&i.cache.hits), Misses: atomic.LoadInt64(&i.cache.misses)}
+}
+
+// cacheEnabled TenantScoped 集合在没有租户或跨租户访问时绕过缓存
+func (i *MongodbGeneric[T]) cacheEnabled() bool {
+	if i.cache == nil || inTransaction(i.ctx, i.database.GetRaw().Client()) {
+		return false
+	}
+	_, _, scoped, _ := tenantScope[T](i.getCtx())
+	return scoped || !hasHook[T, TenantScoped]()
+}
+
+// tablePrefix 键前缀为 数据库.集合:,同一个 Cache 可在多个数据源和集合间共享
+func (i *MongodbGeneric[T]) tablePrefix() string {
+	var r T
+	return i.database.GetRaw().Name() + "." + r.TableName() + ":"
+}
+
+// cachePrefix TenantScoped 集合按租户区分键前缀,没有租户或跨租户访问时为整个集合
+func (i *MongodbGeneric[T]) cachePrefix() string {
+	if _, tenantId, scoped, _ := tenantScope[T](i.getCtx()); scoped {
+		return i.tablePrefix() + "tenant:" + tenantId + ":"
+	}
+	return i.tablePrefix()
+}
+
+func (i *MongodbGeneric[T]) idCacheKey(scope deleteScope, id interface{}) string {
+	if oid, ok := id.(primitive.ObjectID); ok {
+		id = oid.Hex()
+	}
+	return fmt.Sprintf("%sid:%d:%v", i.cachePrefix(), scope, id)
+}
+
+func (i *MongodbGeneric[T]) condCachePrefix() string {
+	return i.cachePrefix() + "cond:"
+}
+
+// cached 读缓存,未命中时调用 load 并写入缓存;命中时重新执行 AfterFind
+func (i *MongodbGeneric[T]) cached(key string, load func() (T, error)) (T, error) {
+	if data, ok := i.cache.cache.Get(key); ok {
+		docs := make([]T, 1)
+		if err := bson.Unmarshal(data, &docs[0]); err == nil {
+			atomic.AddInt64(&i.cache.hits, 1)
+			err = afterFind(i.getCtx(), docs)
+			return docs[0], err
+		}
+	}
+	atomic.AddInt64(&i.cache.misses, 1)
+	doc, err := load()
+	if err != nil {
+		return doc, err
+	}
+	if data, err := bson.Marshal(doc); err == nil {
+		i.cache.cache.Set(key, data, i.cache.ttl)
+	}
+	return doc, nil
+}
+
+// GetByCondCached 与 GetByCond 相同,结果按条件与排序、跳过、数量、投影缓存
+func (i *MongodbGeneric[T]) GetByCondCached(cond bson.M, op *options.FindOptions) (T, error) {
+	if !i.cacheEnabled() {
+		return i.GetByCond(cond, op)
+	}
+	key, err := i.condCacheKey(cond, op)
+	if err != nil {
+		return i.GetByCond(cond, op)
+	}
+	return i.cached(key, func() (T, error) {
+		return i.GetByCond(cond, op)
+	})
+}
+
+// condCacheKey 条件与排序、跳过、数量、投影按规范的 bson 编码后取摘要,相等的条件与选项得到相同的键
+func (i *MongodbGeneric[T]) condCacheKey(cond bson.M, op *options.FindOptions) (string, error) {
+	signature := bson.D{{Key: "scope", Value: int32(i.scope)}, {Key: "cond", Value: canonicalValue(cond)}}
+	if op != nil {
+		signature = append(signature,
+			bson.E{Key: "sort", Value: canonicalValue(op.Sort)},
+			bson.E{Key: "projection", Value: canonicalValue(op.Projection)})
+		if op.Skip != nil {
+			signature = append(signature, bson.E{Key: "skip", Value: *op.Skip})
+		}
+		if op.Limit != nil {
+			signature = append(signature, bson.E{Key: "limit", Value: *op.Limit})
+		}
+	}
+	data, err := bson.Marshal(signature)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(data)
+	return i.condCachePrefix() + hex.EncodeToString(sum[:]), nil
+}
+
+// canonicalValue map 按键排序转换为 bson.D,使编码结果与 map 的遍历顺序无关;bson.D 保持原有顺序(排序依赖字段顺序)
+func canonicalValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case bson.M:
+		return canonicalMap(value)
+	case map[string]interface{}:
+		return canonicalMap(value)
+	case bson.D:
+		doc := make(bson.D, len(value))
+		for index, e := range value {
+			doc[index] = bson.E{Key: e.Key, Value: canonicalValue(e.Value)}
+		}
+		return doc
+	case bson.A:
+		return canonicalSlice(value)
+	case []interface{}:
+		return canonicalSlice(value)
+	}
+	return v
+}
+
+func canonicalMap(m map[string]interface{}) bson.D {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	doc := make(bson.D, len(keys))
+	for index, k := range keys {
+		doc[index] = bson.E{Key: k, Value: canonicalValue(m[k])}
+	}
+	return doc
+}
+
+func canonicalSlice(values []interface{}) bson.A {
+	result := make(bson.A, len(values))
+	for index, v := range values {
+		result[index] = canonicalValue(v)
+	}
+	return result
+}
+
+// invalidate 删除指定 id 的缓存及所有条件查询缓存,写入失败时同样失效(可能已部分写入)
+func (i *MongodbGeneric[T]) invalidate(ids ...interface{}) {
+	if i.cache == nil {
+		return
+	}
+	if hasHook[T, TenantScoped]() && i.cachePrefix() == i.tablePrefix() {
+		// 跨租户写入无法确定租户,失效所有租户的缓存
+		i.invalidateAll()
+		return
+	}
+	var keys []string
+	for _, id := range ids {
+		for _, scope := range []deleteScope{scopeExcludeDeleted, scopeWithDeleted, scopeOnlyDeleted} {
+			keys = append(keys, i.idCacheKey(scope, id))
+		}
+	}
+	if len(keys) > 0 {
+		i.cache.cache.Delete(keys...)
+	}
+	i.cache.cache.DeletePrefix(i.condCachePrefix())
+}
+
+// invalidateAll 删除集合(TenantScoped 集合为当前租户)的全部缓存,用于无法确定影响范围的写入(UpdateSet、批量写入)
+func (i *MongodbGeneric[T]) invalidateAll() {
+	if i.cache == nil {
+		return
+	}
+	i.cache.cache.DeletePrefix(i.cachePrefix())
+}
+
+func stringIds(ids []string) []interface{} {
+	result := make([]interface{}, len(ids))
+	for index, id := range ids {
+		result[index] = id
+	}
+	return result
+}
@@ -0,0 +1,90 @@
+package mongokits
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMemoryCache(t *testing.T) {
+	cache := NewMemoryCache(2)
+	cache.Set("a", []byte("1"), 0)
+	cache.Set("b", []byte("2"), 0)
+	cache.Get("a")
+	cache.Set("c", []byte("3"), 0)
+	if _, ok := cache.Get("b"); ok {
+		t.Fatal("least recently used entry should be evicted")
+	}
+	if _, ok := cache.Get("a"); !ok || cache.Len() != 2 || cache.Evictions() != 1 {
+		t.Fatalf("len %d evictions %d", cache.Len(), cache.Evictions())
+	}
+
+	cache.Set("x:1", []byte("1"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := cache.Get("x:1"); ok {
+		t.Fatal("expired entry returned")
+	}
+	cache.Set("x:2", []byte("2"), 0)
+	cache.DeletePrefix("x:")
+	cache.Delete("a")
+	if cache.Len() != 0 {
+		t.Fatalf("entries left %d", cache.Len())
+	}
+}
+
+// 相等的条件与选项(不同的指针、不同的 map 遍历顺序)得到相同的键,任一选项不同时键不同
+func TestCondCacheKey(t *testing.T) {
+	repo := &MongodbGeneric[*testItem]{database: newFakeDatabase(t, nil)}
+	cond := func() bson.M {
+		return bson.M{"a": 1, "b": bson.M{"$in": bson.A{"x", "y"}}, "c": "z", "d": bson.M{"e": 1, "f": 2}}
+	}
+	find := func(skip int64) *options.FindOptions {
+		return options.Find().SetSkip(skip).SetLimit(10).
+			SetSort(bson.D{{Key: "a", Value: 1}, {Key: "b", Value: -1}}).
+			SetProjection(bson.M{"a": 1, "b": 1, "c": 1})
+	}
+	key, err := repo.condCacheKey(cond(), find(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < 20; n++ {
+		if again, _ := repo.condCacheKey(cond(), find(2)); again != key {
+			t.Fatalf("equal options produced different keys %s, %s", key, again)
+		}
+	}
+	reversed := find(2).SetSort(bson.D{{Key: "b", Value: -1}, {Key: "a", Value: 1}})
+	for _, other := range []*options.FindOptions{find(3), reversed, nil} {
+		if different, _ := repo.condCacheKey(cond(), other); different == key {
+			t.Fatalf("different options produced the same key %s", key)
+		}
+	}
+}
+
+func TestGetByCondCached(t *testing.T) {
+	finds := 0
+	database := newFakeDatabase(t, func(ctx context.Context, op *Operation, next Invoker) error {
+		if op.Name == OpFind {
+			finds++
+			*op.Result.(*[]*testItem) = []*testItem{{Id: primitive.NewObjectID(), Name: "a"}}
+		}
+		return nil
+	})
+	repo := (&MongodbGeneric[*testItem]{database: database}).WithContext(context.Background()).WithCache(NewMemoryCache(10), time.Minute)
+	for n := 0; n < 3; n++ {
+		doc, err := repo.GetByCondCached(bson.M{"name": "a", "kind": 1}, options.Find().SetSkip(0))
+		if err != nil || doc.Name != "a" {
+			t.Fatalf("%v %v", doc, err)
+		}
+	}
+	if stats := repo.CacheStats(); finds != 1 || stats.Hits != 2 || stats.Misses != 1 {
+		t.Fatalf("finds %d stats %+v", finds, stats)
+	}
+	repo.invalidate()
+	if _, err := repo.GetByCondCached(bson.M{"name": "a", "kind": 1}, options.Find().SetSkip(0)); err != nil || finds != 2 {
+		t.Fatalf("invalidated entry should be reloaded: finds %d %v", finds, err)
+	}
+}
@@ -0,0 +1,307 @@
+package mongokits
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const defaultCheckpointCollection = "change_stream_checkpoints"
+
+type OperationType string
+
+const (
+	OperationInsert       OperationType = "insert"
+	OperationUpdate       OperationType = "update"
+	OperationReplace      OperationType = "replace"
+	OperationDelete       OperationType = "delete"
+	OperationDrop         OperationType = "drop"
+	OperationRename       OperationType = "rename"
+	OperationDropDatabase OperationType = "dropDatabase"
+	OperationInvalidate   OperationType = "invalidate"
+)
+
+type ChangeNamespace struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"coll"`
+}
+
+type UpdateDescription struct {
+	UpdatedFields bson.M   `bson:"updatedFields"`
+	RemovedFields []string `bson:"removedFields"`
+}
+
+// ChangeEvent 变更事件,FullDocument 在 insert/replace 时存在,update 需开启 FullDocument 选项,delete 时为零值
+type ChangeEvent[T any] struct {
+	ResumeToken       bson.Raw            `bson:"_id"`
+	OperationType     OperationType       `bson:"operationType"`
+	FullDocument      T                   `bson:"fullDocument"`
+	DocumentKey       bson.M              `bson:"documentKey"`
+	Namespace         ChangeNamespace     `bson:"ns"`
+	UpdateDescription *UpdateDescription  `bson:"updateDescription"`
+	ClusterTime       primitive.Timestamp `bson:"clusterTime"`
+}
+
+type WatchOptions struct {
+	pipeline             mongo.Pipeline
+	operationTypes       []OperationType
+	fullDocument         bool
+	batchSize            int32
+	maxAwaitTime         time.Duration
+	resumeAfter          bson.Raw
+	checkpoint           string
+	checkpointCollection string
+}
+
+func NewWatchOptions() *WatchOptions {
+	return &WatchOptions{checkpointCollection: defaultCheckpointCollection}
+}
+
+// Pipeline 追加过滤或变换事件的聚合阶段,如 $match
+func (op *WatchOptions) Pipeline(stages ...bson.D) *WatchOptions {
+	for _, stage := range stages {
+		op.pipeline = append(op.pipeline, stage)
+	}
+	return op
+}
+
+// OperationTypes 只接收指定类型的事件
+func (op *WatchOptions) OperationTypes(types ...OperationType) *WatchOptions {
+	op.operationTypes = append(op.operationTypes, types...)
+	return op
+}
+
+// FullDocument update 事件同时返回变更后的完整文档(updateLookup)
+func (op *WatchOptions) FullDocument(full bool) *WatchOptions {
+	op.fullDocument = full
+	return op
+}
+
+func (op *WatchOptions) BatchSize(size int32) *WatchOptions {
+	op.batchSize = size
+	return op
+}
+
+func (op *WatchOptions) MaxAwaitTime(d time.Duration) *WatchOptions {
+	op.maxAwaitTime = d
+	return op
+}
+
+// ResumeAfter 从指定的 resume token 之后继续,优先级低于已保存的检查点
+func (op *WatchOptions) ResumeAfter(token bson.Raw) *WatchOptions {
+	op.resumeAfter = token
+	return op
+}
+
+// Checkpoint 以 name 为消费者标识将 resume token 保存到检查点集合,重启后从上次确认的位置继续
+func (op *WatchOptions) Checkpoint(name string) *WatchOptions {
+	op.checkpoint = name
+	return op
+}
+
+func (op *WatchOptions) CheckpointCollection(collection string) *WatchOptions {
+	op.checkpointCollection = collection
+	return op
+}
+
+func (op *WatchOptions) buildPipeline() mongo.Pipeline {
+	pipeline := mongo.Pipeline{}
+	if len(op.operationTypes) > 0 {
+		types := bson.A{}
+		for _, t := range op.operationTypes {
+			types = append(types, string(t))
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": types}}}})
+	}
+	return append(pipeline, op.pipeline...)
+}
+
+type checkpointRecord struct {
+	Name      string    `bson:"_id"`
+	Token     bson.Raw  `bson:"token"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// ChangeStream 类型化的变更流迭代器,不支持并发调用
+type ChangeStream[T any] struct {
+	stream   *mongo.ChangeStream
+	database *MongodbDatabase
+	// checkpoint 检查点集合,为空时不保存检查点
+	checkpoint string
+	name       string
+	current    *ChangeEvent[T]
+	err        error
+}
+
+// watch collection 为空时监听整个数据库,scope 为追加在用户阶段之前的过滤条件,非空时 update 事件需要 FullDocument 才能过滤
+func watch[T any](ctx context.Context, database *MongodbDatabase, collection string, scope mongo.Pipeline, ops []*WatchOptions) (*ChangeStream[T], error) {
+	op := NewWatchOptions()
+	if len(ops) > 0 && ops[0] != nil {
+		op = ops[0]
+	}
+	csOptions := options.ChangeStream()
+	if op.fullDocument || len(scope) > 0 {
+		csOptions.SetFullDocument(options.UpdateLookup)
+	}
+	if op.batchSize > 0 {
+		csOptions.SetBatchSize(op.batchSize)
+	}
+	if op.maxAwaitTime > 0 {
+		csOptions.SetMaxAwaitTime(op.maxAwaitTime)
+	}
+	if op.resumeAfter != nil {
+		csOptions.SetResumeAfter(op.resumeAfter)
+	}
+
+	cs := &ChangeStream[T]{database: database, name: op.checkpoint}
+	if op.checkpoint != "" {
+		cs.checkpoint = op.checkpointCollection
+		var record checkpointRecord
+		err := database.findOne(ctx, cs.checkpoint, bson.M{"_id": op.checkpoint}, &record)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, wrapError(err)
+		}
+		if record.Token != nil {
+			csOptions.SetResumeAfter(record.Token)
+		}
+	}
+
+	stream, err := database.watch(ctx, collection, append(scope, op.buildPipeline()...), csOptions)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	cs.stream = stream
+	return cs, nil
+}
+
+// Next 阻塞等待下一个事件,ctx 取消或出错时返回 false,通过 Err 获取错误
+func (s *ChangeStream[T]) Next(ctx context.Context) bool {
+	s.current = nil
+	if s.err != nil || !s.stream.Next(ctx) {
+		return false
+	}
+	event := &ChangeEvent[T]{}
+	if err := s.stream.Decode(event); err != nil {
+		s.err = err
+		return false
+	}
+	if err := event.afterFind(ctx, s.stream.Current); err != nil {
+		s.err = err
+		return false
+	}
+	s.current = event
+	return true
+}
+
+// afterFind 事件带有 fullDocument 时对其调用 AfterFind,delete 等没有 fullDocument 的事件不调用
+func (e *ChangeEvent[T]) afterFind(ctx context.Context, raw bson.Raw) error {
+	if value, err := raw.LookupErr("fullDocument"); err != nil || value.Type != bsontype.EmbeddedDocument {
+		return nil
+	}
+	if h, ok := lookupHook[AfterFindHook](e.FullDocument, &e.FullDocument); ok {
+		return h.AfterFind(ctx)
+	}
+	return nil
+}
+
+// Event 当前事件,Next 返回 true 后有效
+func (s *ChangeStream[T]) Event() *ChangeEvent[T] {
+	return s.current
+}
+
+// Commit 确认当前事件已处理,将其 resume token 保存到检查点,未设置 Checkpoint 时不做任何操作
+func (s *ChangeStream[T]) Commit(ctx context.Context) error {
+	if s.checkpoint == "" || s.current == nil {
+		return nil
+	}
+	_, err := s.database.updateOne(ctx, s.checkpoint,
+		bson.M{"_id": s.name},
+		bson.M{"$set": bson.M{"token": s.current.ResumeToken, "updated_at": time.Now()}},
+		options.Update().SetUpsert(true))
+	return wrapError(err)
+}
+
+// ResumeToken 最近一个事件之后的 resume token,可用于 ResumeAfter
+func (s *ChangeStream[T]) ResumeToken() bson.Raw {
+	return s.stream.ResumeToken()
+}
+
+func (s *ChangeStream[T]) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return wrapError(s.stream.Err())
+}
+
+func (s *ChangeStream[T]) Close(ctx context.Context) error {
+	return wrapError(s.stream.Close(ctx))
+}
+
+// Consume 持续读取事件并调用 handler,handler 成功后保存检查点;handler 返回错误或 ctx 取消时停止并关闭变更流
+// 检查点在处理之后保存,重启后可能重复收到最后一个事件,handler 需保证幂等
+func (s *ChangeStream[T]) Consume(ctx context.Context, handler func(event *ChangeEvent[T]) error) error {
+	defer s.Close(context.Background())
+	for s.Next(ctx) {
+		if err := handler(s.current); err != nil {
+			return err
+		}
+		if err := s.Commit(ctx); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Err()
+}
+
+// Watch 监听整个数据库的变更,FullDocument 为原始 bson
+func (i *MongodbDatabase) Watch(ops ...*WatchOptions) (*ChangeStream[bson.Raw], error) {
+	return watch[bson.Raw](i.client.GetCtx(), i, "", nil, ops)
+}
+
+// WatchTable 监听指定集合的变更
+func (i *MongodbDatabase) WatchTable(tableName string, ops ...*WatchOptions) (*ChangeStream[bson.Raw], error) {
+	return watch[bson.Raw](i.client.GetCtx(), i, tableName, nil, ops)
+}
+
+// watchTable 租户隔离的表只接收 ctx 中租户的事件,ctx 中没有租户时返回 ErrorTenantRequired,WithCrossTenant 时接收全部
+// 租户条件作用于 fullDocument,delete 事件没有 fullDocument,不会推送给租户隔离的变更流
+func watchTable[T Table](ctx context.Context, database *MongodbDatabase, ops []*WatchOptions) (*ChangeStream[T], error) {
+	var r T
+	field, tenantId, scoped, err := tenantScope[T](ctx)
+	if err != nil {
+		return nil, err
+	}
+	var scope mongo.Pipeline
+	if scoped {
+		scope = mongo.Pipeline{{{Key: "$match", Value: bson.M{"fullDocument." + field: tenantId}}}}
+	}
+	return watch[T](ctx, database, r.TableName(), scope, ops)
+}
+
+// Watch 监听 T 对应集合的变更,FullDocument 解码为 T
+func (i *MongodbGeneric[T]) Watch(ops ...*WatchOptions) (*ChangeStream[T], error) {
+	return watchTable[T](i.getCtx(), i.database, ops)
+}
+
+func (i *MongodbGenericComplex[T]) Watch(ops ...*WatchOptions) (*ChangeStream[T], error) {
+	return watchTable[T](i.getCtx(), i.writer, ops)
+}
+
+func Watch[T Table](ops ...*WatchOptions) (*ChangeStream[T], error) {
+	return WatchContext[T](context.TODO(), ops...)
+}
+
+// WatchContext 按 ctx 中的租户选择数据源并监听 T 对应集合的变更
+func WatchContext[T Table](ctx context.Context, ops ...*WatchOptions) (*ChangeStream[T], error) {
+	database, err := defaultDatabase[T](ctx)
+	if err != nil {
+		return nil, err
+	}
+	return watchTable[T](ctx, database, ops)
+}
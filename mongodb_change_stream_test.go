@@ -0,0 +1,107 @@
+package mongokits
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type tenantNote struct {
+	Id       primitive.ObjectID `bson:"_id"`
+	TenantId string             `bson:"tenant_id"`
+	Text     string             `bson:"text"`
+}
+
+func (n *tenantNote) TableName() string       { return "test_tenant_notes" }
+func (n *tenantNote) PrimaryKey() interface{} { return n.Id }
+func (n *tenantNote) PrimaryKeyName() string  { return "_id" }
+func (n *tenantNote) TenantField() string     { return "tenant_id" }
+func (n *tenantNote) GetTenantId() string     { return n.TenantId }
+func (n *tenantNote) SetTenantId(id string)   { n.TenantId = id }
+
+type loadedNote struct {
+	Text   string `bson:"text"`
+	Loaded bool   `bson:"-"`
+}
+
+func (n *loadedNote) AfterFind(ctx context.Context) error {
+	n.Loaded = true
+	return nil
+}
+
+// 带 fullDocument 的事件调用 AfterFind,值类型与指针类型均生效,delete 事件不调用
+func TestChangeEventAfterFind(t *testing.T) {
+	insert, _ := bson.Marshal(bson.M{"operationType": "insert", "fullDocument": bson.M{"text": "a"}})
+	remove, _ := bson.Marshal(bson.M{"operationType": "delete"})
+
+	value := &ChangeEvent[loadedNote]{}
+	if err := bson.Unmarshal(insert, value); err != nil {
+		t.Fatal(err)
+	}
+	if err := value.afterFind(context.Background(), insert); err != nil || !value.FullDocument.Loaded {
+		t.Fatalf("value document not loaded: %v", err)
+	}
+	pointer := &ChangeEvent[*loadedNote]{}
+	if err := bson.Unmarshal(insert, pointer); err != nil {
+		t.Fatal(err)
+	}
+	if err := pointer.afterFind(context.Background(), insert); err != nil || !pointer.FullDocument.Loaded {
+		t.Fatalf("pointer document not loaded: %v", err)
+	}
+	deleted := &ChangeEvent[*loadedNote]{}
+	if err := bson.Unmarshal(remove, deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleted.afterFind(context.Background(), remove); err != nil || deleted.FullDocument != nil {
+		t.Fatalf("delete event: %v", err)
+	}
+}
+
+func TestWatchTenantRequired(t *testing.T) {
+	if _, err := watchTable[*tenantNote](context.Background(), nil, nil); !IsTenantRequired(err) {
+		t.Fatalf("expected tenant required, got %v", err)
+	}
+}
+
+// 租户隔离的变更流只收到当前租户的事件,WithCrossTenant 收到全部
+func TestWatchTenantScoped(t *testing.T) {
+	database := openTestDatabase(t, (&tenantNote{}).TableName())
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	scoped, err := WatchContext[*tenantNote](WithTenant(ctx, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scoped.Close(ctx)
+	cross, err := WatchContext[*tenantNote](WithCrossTenant(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cross.Close(ctx)
+
+	for _, tenant := range []string{"b", "a"} {
+		note := &tenantNote{Id: primitive.NewObjectID(), TenantId: tenant, Text: tenant}
+		if _, err := database.GetRaw().Collection(note.TableName()).InsertOne(ctx, note); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !scoped.Next(ctx) {
+		t.Fatal(scoped.Err())
+	}
+	if got := scoped.Event().FullDocument.TenantId; got != "a" {
+		t.Fatalf("scoped stream received tenant %q", got)
+	}
+	for _, want := range []string{"b", "a"} {
+		if !cross.Next(ctx) {
+			t.Fatal(cross.Err())
+		}
+		if got := cross.Event().FullDocument.TenantId; got != want {
+			t.Fatalf("cross tenant stream received %q, want %q", got, want)
+		}
+	}
+}
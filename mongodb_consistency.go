@@ -0,0 +1,88 @@
+package mongokits
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// writeTracker 记录 context 中最近一次写入的时间,同一 context 派生出的仓库共享
+type writeTracker struct {
+	lastWrite int64
+}
+
+type writeTrackerKey struct{}
+
+/*
+*
+WithReadYourWrites 在 context 中开启写入跟踪,使用该 ctx(或其派生 ctx)的读写分离仓库在写入后的窗口期内从写库读取
+写库与读库为不同客户端,因果一致会话无法跨客户端使用,因此以窗口期内路由到写库的方式保证读到自己的写入
+*/
+func WithReadYourWrites(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	if _, ok := ctx.Value(writeTrackerKey{}).(*writeTracker); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, writeTrackerKey{}, &writeTracker{})
+}
+
+func trackerFromContext(ctx context.Context) *writeTracker {
+	if ctx == nil {
+		return nil
+	}
+	tracker, _ := ctx.Value(writeTrackerKey{}).(*writeTracker)
+	return tracker
+}
+
+// mark 记录写入时间,tracker 为 nil(未开启写入跟踪)时不做任何操作
+func (t *writeTracker) mark() {
+	if t != nil {
+		atomic.StoreInt64(&t.lastWrite, time.Now().UnixNano())
+	}
+}
+
+// writtenWithin 判断 window 内是否有过写入
+func (t *writeTracker) writtenWithin(window time.Duration) bool {
+	if t == nil {
+		return false
+	}
+	lastWrite := atomic.LoadInt64(&t.lastWrite)
+	return lastWrite > 0 && time.Since(time.Unix(0, lastWrite)) < window
+}
+
+// ReadYourWrites 返回开启读己之写的副本:写入后 window 内的查询从写库读取,绑定的 ctx 自动开启写入跟踪,
+// 未绑定 ctx 时副本自带写入跟踪,由该副本及其 WithContext 出的副本共享
+// 多个仓库需共享写入状态时,先用 WithReadYourWrites 包装 ctx 再分别 WithContext
+func (i *MongodbGenericComplex[T]) ReadYourWrites(window time.Duration) *MongodbGenericComplex[T] {
+	g := *i
+	g.window = window
+	if window > 0 {
+		if g.ctx != nil {
+			g.ctx = WithReadYourWrites(g.ctx)
+		} else {
+			g.tracker = &writeTracker{}
+		}
+	}
+	return &g
+}
+
+// AllowStale 返回容忍延迟的副本,查询始终从读库读取(事务中除外),不受读己之写窗口影响
+func (i *MongodbGenericComplex[T]) AllowStale() *MongodbGenericComplex[T] {
+	g := *i
+	g.stale = true
+	return &g
+}
+
+// writes 写入跟踪,优先使用 ctx 中的,ctx 未开启时使用 ReadYourWrites 创建的
+func (i *MongodbGenericComplex[T]) writes() *writeTracker {
+	if tracker := trackerFromContext(i.ctx); tracker != nil {
+		return tracker
+	}
+	return i.tracker
+}
+
+func (i *MongodbGenericComplex[T]) markWrite() {
+	i.writes().mark()
+}
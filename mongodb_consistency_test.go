@@ -0,0 +1,53 @@
+package mongokits
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 未绑定 ctx 的副本写入后窗口期内从写库读取
+func TestReadYourWritesWithoutContext(t *testing.T) {
+	openTestDatabase(t, (&testItem{}).TableName())
+	repo, err := GetGenericComplexDatabase[*testItem]("default", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo = repo.ReadYourWrites(time.Minute)
+	if repo.readNode() == nil {
+		t.Fatal("reads should go to the reader before any write")
+	}
+
+	item := &testItem{Id: primitive.NewObjectID(), Name: "first"}
+	if _, err := repo.Insert(item); err != nil {
+		t.Fatal(err)
+	}
+	if repo.readNode() != nil {
+		t.Fatal("reads within the window should go to the writer")
+	}
+	got, err := repo.GetById(item.Id.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "first" {
+		t.Fatalf("unexpected document %+v", got)
+	}
+	if repo.AllowStale().readNode() == nil {
+		t.Fatal("stale reads should go to the reader")
+	}
+}
+
+func TestReadYourWritesTracksCopies(t *testing.T) {
+	repo := (&MongodbGenericComplex[*testItem]{}).ReadYourWrites(time.Minute)
+	if repo.writes() == nil {
+		t.Fatal("ReadYourWrites without ctx should create a tracker")
+	}
+	repo.markWrite()
+	if !repo.writes().writtenWithin(time.Minute) {
+		t.Fatal("write should be visible to the same copy")
+	}
+	if !repo.AllowStale().writes().writtenWithin(time.Minute) {
+		t.Fatal("copies should share the tracker")
+	}
+}
@@ -0,0 +1,44 @@
+package mongokits
+
+import (
+	"context"
+)
+
+/*
+*
+Datasourced Table 实现该接口后,包级函数与 GetGenericDatabase[T] 使用声明的数据源,不再按租户选择数据源
+DatasourceId 为空时使用默认数据源,DatabaseName 为空时使用数据源配置的数据库
+*/
+type Datasourced interface {
+	DatasourceId() string
+	DatabaseName() string
+}
+
+// tableDatabase Table 声明了数据源时返回该数据源,否则返回 ctx 中租户的数据源或默认数据源
+func tableDatabase(ctx context.Context, table interface{}, ptr interface{}) (*MongodbDatabase, error) {
+	d, ok := lookupHook[Datasourced](table, ptr)
+	if !ok {
+		return contextDatabase(ctx)
+	}
+	var database *MongodbDatabase
+	var err error
+	if id := d.DatasourceId(); id != "" {
+		database, err = GetDefaultManager().GetDatabaseById(id)
+	} else {
+		database, err = GetDefaultManager().GetDatabase()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return database.withDatabaseName(d.DatabaseName()), nil
+}
+
+// defaultDatabase T 使用的数据源,以 ctx 为父 context,ctx 为 WithTransaction 的 txCtx 时操作加入该事务
+func defaultDatabase[T Table](ctx context.Context) (*MongodbDatabase, error) {
+	var r T
+	database, err := tableDatabase(ctx, r, &r)
+	if err != nil {
+		return nil, err
+	}
+	return database.WithContext(ctx), nil
+}
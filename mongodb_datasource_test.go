@@ -0,0 +1,81 @@
+package mongokits
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// archivedItem 使用默认数据源下的 archive 数据库
+type archivedItem struct {
+	Id primitive.ObjectID `bson:"_id"`
+}
+
+func (a archivedItem) TableName() string       { return "test_archived_items" }
+func (a archivedItem) PrimaryKey() interface{} { return a.Id }
+func (a archivedItem) PrimaryKeyName() string  { return "_id" }
+func (a *archivedItem) DatasourceId() string   { return "" }
+func (a *archivedItem) DatabaseName() string   { return "archive" }
+
+// 包级函数与 GetGenericDatabase 按 Datasourced 选择数据源,未声明时按 ctx 中的租户选择
+func TestDatasourceRouting(t *testing.T) {
+	var seen []string
+	named := func(name string) *MongodbDatabase {
+		return newFakeDatabase(t, func(ctx context.Context, op *Operation, next Invoker) error {
+			seen = append(seen, name+"/"+op.Database)
+			return nil
+		})
+	}
+	useFakeFactory(t, map[string]*MongodbDatabase{"": named("default"), "orders": named("orders"), "tenants": named("tenants")})
+	if err := SetTenantResolver(func(tenantId string) (string, string, error) {
+		return "tenants", "tenant_" + tenantId, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tenantCtx := WithTenant(context.Background(), "a")
+	for _, c := range []struct {
+		name  string
+		query func() error
+		want  string
+	}{
+		{"default", func() error {
+			_, err := QueryByCondContext[*testItem](context.Background(), bson.M{}, nil)
+			return err
+		}, "default/test"},
+		{"tenant", func() error {
+			_, err := QueryByCondContext[*testItem](tenantCtx, bson.M{}, nil)
+			return err
+		}, "tenants/tenant_a"},
+		{"cross tenant", func() error {
+			_, err := QueryByCondContext[*testItem](WithCrossTenant(tenantCtx), bson.M{}, nil)
+			return err
+		}, "default/test"},
+		{"datasourced", func() error {
+			_, err := QueryByCondContext[*orderItem](tenantCtx, bson.M{}, nil)
+			return err
+		}, "orders/test"},
+		{"database name", func() error {
+			_, err := QueryByCondContext[archivedItem](context.Background(), bson.M{}, nil)
+			return err
+		}, "default/archive"},
+		{"generic database", func() error {
+			repo, err := GetGenericDatabase[*orderItem]()
+			if err != nil {
+				return err
+			}
+			_, err = repo.QueryByCond(bson.M{}, nil)
+			return err
+		}, "orders/test"},
+	} {
+		seen = nil
+		if err := c.query(); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(seen) != 1 || seen[0] != c.want {
+			t.Errorf("%s: routed to %v, want %s", c.name, seen, c.want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package mongokits
+
+import (
+	"bytes"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type sortKey struct {
+	field     []string
+	ascending bool
+}
+
+// parseSort 解析 FindOptions.Sort,支持 bson.D 与单个字段的 bson.M
+func parseSort(spec interface{}) ([]sortKey, error) {
+	var elements bson.D
+	switch v := spec.(type) {
+	case nil:
+		return nil, nil
+	case bson.D:
+		elements = v
+	case bson.M:
+		if len(v) > 1 {
+			return nil, fmt.Errorf("sort with multiple fields must be bson.D")
+		}
+		for k, value := range v {
+			elements = append(elements, bson.E{Key: k, Value: value})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported sort type %T", spec)
+	}
+	keys := make([]sortKey, 0, len(elements))
+	for _, e := range elements {
+		direction, _ := normalizeKey(e.Value)
+		var ascending bool
+		switch d := direction.(type) {
+		case int64:
+			ascending = d >= 0
+		case float64:
+			ascending = d >= 0
+		default:
+			return nil, fmt.Errorf("unsupported sort direction %v for %s", e.Value, e.Key)
+		}
+		keys = append(keys, sortKey{field: strings.Split(e.Key, "."), ascending: ascending})
+	}
+	return keys, nil
+}
+
+// normalizeKey 将数值统一为 int64 或 float64,时间统一为 time.Time,返回 false 表示不支持比较的类型
+func normalizeKey(v interface{}) (interface{}, bool) {
+	switch k := v.(type) {
+	case nil, string, bool, primitive.ObjectID, time.Time:
+		return k, true
+	case int:
+		return int64(k), true
+	case int8:
+		return int64(k), true
+	case int16:
+		return int64(k), true
+	case int32:
+		return int64(k), true
+	case int64:
+		return k, true
+	case uint8:
+		return int64(k), true
+	case uint16:
+		return int64(k), true
+	case uint32:
+		return int64(k), true
+	case uint:
+		return normalizeUint(uint64(k)), true
+	case uint64:
+		return normalizeUint(k), true
+	case float32:
+		return float64(k), true
+	case float64:
+		return k, true
+	case primitive.DateTime:
+		return time.Unix(int64(k)/1000, int64(k)%1000*int64(time.Millisecond)), true
+	}
+	return nil, false
+}
+
+// normalizeUint 超出 int64 范围的无符号整数按 float64 比较
+func normalizeUint(v uint64) interface{} {
+	if v > math.MaxInt64 {
+		return float64(v)
+	}
+	return int64(v)
+}
+
+// keyRank 不同类型之间按 MongoDB 的比较顺序:null < 数值 < 字符串 < ObjectId < 布尔 < 时间
+func keyRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	case primitive.ObjectID:
+		return 3
+	case bool:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// compareKeys 比较两个已 normalizeKey 的值
+func compareKeys(a, b interface{}) int {
+	ra, rb := keyRank(a), keyRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+	switch x := a.(type) {
+	case int64:
+		if y, ok := b.(int64); ok {
+			return compareOrdered(x, y)
+		}
+		return compareOrdered(float64(x), b.(float64))
+	case float64:
+		if y, ok := b.(int64); ok {
+			return compareOrdered(x, float64(y))
+		}
+		return compareOrdered(x, b.(float64))
+	case string:
+		return strings.Compare(x, b.(string))
+	case primitive.ObjectID:
+		y := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		y := b.(bool)
+		if x == y {
+			return 0
+		}
+		if !x {
+			return -1
+		}
+		return 1
+	case time.Time:
+		y := b.(time.Time)
+		if x.Before(y) {
+			return -1
+		}
+		if x.After(y) {
+			return 1
+		}
+	}
+	return 0
+}
+
+func compareOrdered[K int64 | float64](a, b K) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// rawKey 将 bson 值转换为可比较的值,不支持的类型与缺失字段视为 null
+func rawKey(rv bson.RawValue) interface{} {
+	switch rv.Type {
+	case bsontype.Double:
+		return rv.Double()
+	case bsontype.Int32:
+		return int64(rv.Int32())
+	case bsontype.Int64:
+		return rv.Int64()
+	case bsontype.String:
+		return rv.StringValue()
+	case bsontype.ObjectID:
+		return rv.ObjectID()
+	case bsontype.Boolean:
+		return rv.Boolean()
+	case bsontype.DateTime:
+		return rv.Time()
+	}
+	return nil
+}
+
+// sortDocuments 按排序字段对文档稳定排序,字段值取自文档的 bson 编码
+func sortDocuments[T Table](docs []T, keys []sortKey) {
+	values := make([][]interface{}, len(docs))
+	for index, doc := range docs {
+		raw, _ := bson.Marshal(doc)
+		row := make([]interface{}, len(keys))
+		for pos, key := range keys {
+			if raw != nil {
+				row[pos] = rawKey(bson.Raw(raw).Lookup(key.field...))
+			}
+		}
+		values[index] = row
+	}
+	order := make([]int, len(docs))
+	for index := range order {
+		order[index] = index
+	}
+	sort.SliceStable(order, func(x, y int) bool {
+		for pos, key := range keys {
+			c := compareKeys(values[order[x]][pos], values[order[y]][pos])
+			if c == 0 {
+				continue
+			}
+			return (c < 0) == key.ascending
+		}
+		return false
+	})
+	sorted := make([]T, len(docs))
+	for index, from := range order {
+		sorted[index] = docs[from]
+	}
+	copy(docs, sorted)
+}
+
+/*
+*
+fanOutQuery 在多个目标上执行同一查询并合并结果,op 的排序、跳过与数量作用于合并后的结果:
+每个目标查询 skip+limit 条,合并后排序再跳过与截取;concurrent 为 false 时按顺序查询(事务中会话不支持并发)
+*/
+func fanOutQuery[T Table](queries []func(op *options.FindOptions) ([]T, error), op *options.FindOptions, concurrent bool) ([]T, error) {
+	if op == nil {
+		op = &options.FindOptions{}
+	}
+	keys, err := parseSort(op.Sort)
+	if err != nil {
+		return nil, err
+	}
+	var skip, limit int64
+	if op.Skip != nil {
+		skip = *op.Skip
+	}
+	if op.Limit != nil {
+		limit = *op.Limit
+		if limit < 0 {
+			limit = -limit
+		}
+	}
+	target := *op
+	target.Skip = nil
+	if limit > 0 {
+		total := skip + limit
+		target.Limit = &total
+	}
+
+	results := make([][]T, len(queries))
+	errs := make([]error, len(queries))
+	if concurrent && len(queries) > 1 {
+		var wg sync.WaitGroup
+		for index, query := range queries {
+			wg.Add(1)
+			go func(index int, query func(op *options.FindOptions) ([]T, error)) {
+				defer wg.Done()
+				o := target
+				results[index], errs[index] = query(&o)
+			}(index, query)
+		}
+		wg.Wait()
+	} else {
+		for index, query := range queries {
+			o := target
+			if results[index], errs[index] = query(&o); errs[index] != nil {
+				break
+			}
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var merged []T
+	for _, result := range results {
+		merged = append(merged, result...)
+	}
+	if len(keys) > 0 {
+		sortDocuments(merged, keys)
+	}
+	if skip >= int64(len(merged)) {
+		return nil, nil
+	}
+	merged = merged[skip:]
+	if limit > 0 && limit < int64(len(merged)) {
+		merged = merged[:limit]
+	}
+	return merged, nil
+}
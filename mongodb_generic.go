@@ -11,10 +11,15 @@ import (
 
 type MongodbGeneric[T Table] struct {
 	database *MongodbDatabase
+	scope    deleteScope
+	ctx      context.Context
+	cache    *repoCache
 }
 
+// GetGenericDatabase T 实现 Datasourced 时使用声明的数据源,否则使用默认数据源
 func GetGenericDatabase[T Table]() (*MongodbGeneric[T], error) {
-	db, err := GetDefaultManager().GetDatabase()
+	var r T
+	db, err := tableDatabase(context.TODO(), r, &r)
 	if nil != err {
 		return nil, err
 	}
@@ -22,6 +27,61 @@ func GetGenericDatabase[T Table]() (*MongodbGeneric[T], error) {
 		database: db,
 	}, nil
 }
+
+// queryAll 查询并解码结果,按删除范围与租户追加过滤条件并调用 AfterFind
+func queryAll[T Table](ctx context.Context, database *MongodbDatabase, scope deleteScope, cond interface{}, op *options.FindOptions) ([]T, error) {
+	var r T
+	return queryCollection[T](ctx, database, r.TableName(), scope, cond, op)
+}
+
+// queryCollection 与 queryAll 相同,查询指定的集合
+func queryCollection[T Table](ctx context.Context, database *MongodbDatabase, collection string, scope deleteScope, cond interface{}, op *options.FindOptions) ([]T, error) {
+	var result []T
+	filter, err := tenantFilter[T](ctx, scopeFilter[T](scope, cond))
+	if err != nil {
+		return nil, err
+	}
+	if err := database.QueryAllByCondition(collection, filter, op, &result); err != nil {
+		return nil, err
+	}
+	if err := afterFind(ctx, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// WithContext 返回绑定 ctx 的副本,后续操作的钩子、操作人等从该 ctx 中读取
+func (i *MongodbGeneric[T]) WithContext(ctx context.Context) *MongodbGeneric[T] {
+	g := *i
+	g.ctx = ctx
+	g.database = i.database.WithContext(ctx)
+	return &g
+}
+
+func (i *MongodbGeneric[T]) getCtx() context.Context {
+	if i.ctx == nil {
+		return context.TODO()
+	}
+	return i.ctx
+}
+
+// queryById 按 ObjectId 查询单个文档,不存在时返回 ErrorDocumentNotFound
+func queryById[T Table](ctx context.Context, database *MongodbDatabase, scope deleteScope, id string) (T, error) {
+	var r T
+	oid, err := parseObjectId(id)
+	if err != nil {
+		return r, err
+	}
+	result, err := queryAll[T](ctx, database, scope, bson.M{"_id": oid}, &options.FindOptions{})
+	if err != nil {
+		return r, err
+	}
+	if len(result) == 0 {
+		return r, ErrorDocumentNotFound
+	}
+	return result[0], nil
+}
+
 func (i *MongodbGeneric[T]) GetRaw() *mongo.Database {
 	return i.database.GetRaw()
 }
@@ -35,15 +95,34 @@ func GetGenericDatabaseById[T Table](dbId string) (*MongodbGeneric[T], error) {
 	}, nil
 }
 func (i *MongodbGeneric[T]) Count(filter bson.M) (int64, error) {
+	return countDocuments[T](i.getCtx(), i.database, i.scope, filter)
+}
+
+func countDocuments[T Table](ctx context.Context, database *MongodbDatabase, scope deleteScope, cond bson.M) (int64, error) {
 	var r T
-	return i.database.GetCountByCondition(r.TableName(), filter)
+	return countCollection[T](ctx, database, r.TableName(), scope, cond)
+}
+
+func countCollection[T Table](ctx context.Context, database *MongodbDatabase, collection string, scope deleteScope, cond bson.M) (int64, error) {
+	filter, err := tenantFilter[T](ctx, scopeFilter[T](scope, cond))
+	if err != nil {
+		return 0, err
+	}
+	return database.GetCountByCondition(collection, filter)
 }
 
 func (i *MongodbGeneric[T]) InsertAll(tables []interface{}) (int, []interface{}, error) {
 	var r T
-	result, err := i.database.GetRaw().Collection(r.TableName()).InsertMany(context.TODO(), tables)
+	defer i.invalidate()
+	tables = addressableAll(tables)
+	for _, table := range tables {
+		if err := prepareInsert(i.getCtx(), table, nil); err != nil {
+			return 0, nil, err
+		}
+	}
+	result, err := i.database.insertMany(i.getCtx(), r.TableName(), tables)
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, wrapError(err)
 	}
 	totalCount := len(tables)
 	insertCount := len(result.InsertedIDs)
@@ -55,6 +134,11 @@ func (i *MongodbGeneric[T]) InsertAll(tables []interface{}) (int, []interface{},
 }
 
 func (i *MongodbGeneric[T]) Insert(table Table) (string, error) {
+	defer i.invalidate()
+	table = addressable(table)
+	if err := prepareInsert(i.getCtx(), table, nil); err != nil {
+		return "", err
+	}
 	instanceId, err := i.database.Save(table)
 	if err != nil {
 		return "", err
@@ -73,15 +157,10 @@ func (i *MongodbGeneric[T]) Insert(table Table) (string, error) {
 }
 
 func (i *MongodbGeneric[T]) QueryByCond(cond interface{}, op *options.FindOptions) ([]T, error) {
-	var result []T
-	var r T
-	return result, i.database.QueryAllByCondition(r.TableName(), cond, op, &result)
+	return queryAll[T](i.getCtx(), i.database, i.scope, cond, op)
 }
 
 func (i *MongodbGeneric[T]) GetAll(page *Page) ([]T, error) {
-	var result []T
-	var r T
-
 	op := &options.FindOptions{}
 	if nil != page && page.Page > 0 && page.PageSize > 0 {
 		ps := int64(page.PageSize)
@@ -89,7 +168,7 @@ func (i *MongodbGeneric[T]) GetAll(page *Page) ([]T, error) {
 		op.Limit = &ps
 		op.Skip = &skip
 	}
-	return result, i.database.QueryAllByCondition(r.TableName(), bson.M{}, op, &result)
+	return queryAll[T](i.getCtx(), i.database, i.scope, bson.M{}, op)
 }
 
 func (i *MongodbGeneric[T]) GetAllByCond(cond map[string]interface{}, page *Page) ([]T, error) {
@@ -101,96 +180,94 @@ func (i *MongodbGeneric[T]) GetAllByCond(cond map[string]interface{}, page *Page
 		op.Limit = &ps
 		op.Skip = &skip
 	}
-	var result []T
-	var r T
-	return result, i.database.QueryAllByCondition(r.TableName(), c, op, &result)
+	return queryAll[T](i.getCtx(), i.database, i.scope, c, op)
 }
 
 func (i *MongodbGeneric[T]) GetByCond(cond bson.M, op *options.FindOptions) (T, error) {
 	var r T
 	result, err := i.QueryByCond(cond, op)
 	if err != nil {
-		return r, nil
+		return r, err
 	}
 
 	if len(result) == 0 {
-		return r, nil
+		return r, ErrorDocumentNotFound
 	}
 	return result[0], nil
 }
 
 func (i *MongodbGeneric[T]) GetById(id string) (T, error) {
-	var r T
-	oid, _ := primitive.ObjectIDFromHex(id)
-	result, err := i.QueryByCond(bson.M{
-		"_id": oid,
-	}, &options.FindOptions{})
-	if err != nil {
-		return r, err
+	if i.cacheEnabled() {
+		return i.cached(i.idCacheKey(i.scope, id), func() (T, error) {
+			return queryById[T](i.getCtx(), i.database, i.scope, id)
+		})
 	}
-
-	if len(result) == 0 {
-		var z T
-		return z, nil
-	}
-	return result[0], nil
+	return queryById[T](i.getCtx(), i.database, i.scope, id)
 }
 
 func (i *MongodbGeneric[T]) Update(doc T) error {
-	database, err := GetDefaultManager().GetDatabase()
-	if err != nil {
+	defer i.invalidate(doc.PrimaryKey())
+	if err := prepareUpdate(i.getCtx(), doc, &doc); err != nil {
 		return err
 	}
-	//oid, _ := primitive.ObjectIDFromHex(doc.PrimaryKey())
-	return database.Update(doc, bson.M{"_id": doc.PrimaryKey()})
+	return updateDocument(i.database, doc)
 }
 
 func (i *MongodbGeneric[T]) UpdateAll(tables []Table) (int64, int64, error) {
-	database, err := GetDefaultManager().GetDatabase()
-	if err != nil {
-		return 0, 0, err
-	}
+	defer i.invalidateAll()
 	var r T
-	var writers []mongo.WriteModel
+	tables = addressableAll(tables)
 	for _, table := range tables {
-		writers = append(writers, mongo.NewReplaceOneModel().SetFilter(bson.M{table.PrimaryKeyName(): table.PrimaryKey()}).SetReplacement(table).SetUpsert(true))
+		if err := prepareUpdate(i.getCtx(), table, nil); err != nil {
+			return 0, 0, err
+		}
 	}
-	result, err := database.GetRaw().Collection(r.TableName()).BulkWrite(context.TODO(), writers, options.BulkWrite().SetOrdered(false))
-	if err != nil {
-		return 0, 0, err
-	}
-	return result.ModifiedCount, result.InsertedCount, nil
+	return replaceAll(i.getCtx(), i.database, r.TableName(), tables)
 }
 
 func (i *MongodbGeneric[T]) UpdateSet(cond bson.M, setter bson.M) error {
-	var r T
-	if _, err := i.database.GetRaw().Collection(r.TableName()).UpdateOne(context.TODO(), cond, setter); err != nil {
-		return err
-	}
-	return nil
+	defer i.invalidateAll()
+	return updateSet[T](i.getCtx(), i.database, cond, setter)
 }
 
 func (i *MongodbGeneric[T]) Delete(ids ...string) error {
+	defer i.invalidate(stringIds(ids)...)
+	return deleteByIds[T](i.getCtx(), i.database, ids, false)
+}
+
+func (i *MongodbGeneric[T]) Aggregate(pipeline mongo.Pipeline) ([]bson.M, error) {
+	return aggregate[T](i.getCtx(), i.database, i.scope, pipeline)
+}
+
+func aggregate[T Table](ctx context.Context, database *MongodbDatabase, scope deleteScope, pipeline mongo.Pipeline) ([]bson.M, error) {
 	var r T
-	var oid []primitive.ObjectID
-	for _, id := range ids {
-		o, _ := primitive.ObjectIDFromHex(id)
-		oid = append(oid, o)
+	pipeline, err := tenantPipeline[T](ctx, scopePipeline[T](scope, pipeline))
+	if err != nil {
+		return nil, err
 	}
-	_, err := i.database.GetRaw().Collection(r.TableName()).DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$in": oid}})
-	return err
+	return database.Aggregate(r.TableName(), pipeline)
 }
 
 // InsertAll 批量新增数据,返回参数int = 新增数量, []interface{}=写入数据ID, error=异常
 func InsertAll[T Table](tables []interface{}) (int, []interface{}, error) {
-	database, err := GetDefaultManager().GetDatabase()
+	return InsertAllContext[T](context.TODO(), tables)
+}
+
+func InsertAllContext[T Table](ctx context.Context, tables []interface{}) (int, []interface{}, error) {
+	database, err := defaultDatabase[T](ctx)
 	if err != nil {
 		return 0, nil, err
 	}
+	tables = addressableAll(tables)
+	for _, table := range tables {
+		if err := prepareInsert(ctx, table, nil); err != nil {
+			return 0, nil, err
+		}
+	}
 	var r T
-	result, err := database.GetRaw().Collection(r.TableName()).InsertMany(context.TODO(), tables)
+	result, err := database.insertMany(ctx, r.TableName(), tables)
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, wrapError(err)
 	}
 	totalCount := len(tables)
 	insertCount := len(result.InsertedIDs)
@@ -202,10 +279,19 @@ func InsertAll[T Table](tables []interface{}) (int, []interface{}, error) {
 }
 
 func Insert(table Table) (string, error) {
-	database, err := GetDefaultManager().GetDatabase()
+	return InsertContext(context.TODO(), table)
+}
+
+func InsertContext(ctx context.Context, table Table) (string, error) {
+	database, err := tableDatabase(ctx, table, nil)
 	if err != nil {
 		return "", err
 	}
+	database = database.WithContext(ctx)
+	table = addressable(table)
+	if err := prepareInsert(ctx, table, nil); err != nil {
+		return "", err
+	}
 
 	instanceId, err := database.Save(table)
 	if err != nil {
@@ -225,23 +311,26 @@ func Insert(table Table) (string, error) {
 }
 
 func QueryByCond[T Table](cond interface{}, op *options.FindOptions) ([]T, error) {
-	database, err := GetDefaultManager().GetDatabase()
+	return QueryByCondContext[T](context.TODO(), cond, op)
+}
+
+func QueryByCondContext[T Table](ctx context.Context, cond interface{}, op *options.FindOptions) ([]T, error) {
+	database, err := defaultDatabase[T](ctx)
 	if err != nil {
 		return nil, err
 	}
-	var result []T
-	var r T
-	return result, database.QueryAllByCondition(r.TableName(), cond, op, &result)
+	return queryAll[T](ctx, database, scopeExcludeDeleted, cond, op)
 }
 
 func GetAll[T Table](page *Page) ([]T, error) {
-	database, err := GetDefaultManager().GetDatabase()
+	return GetAllContext[T](context.TODO(), page)
+}
+
+func GetAllContext[T Table](ctx context.Context, page *Page) ([]T, error) {
+	database, err := defaultDatabase[T](ctx)
 	if err != nil {
 		return nil, err
 	}
-	var result []T
-	var r T
-
 	op := &options.FindOptions{}
 	if nil != page && page.Page > 0 && page.PageSize > 0 {
 		ps := int64(page.PageSize)
@@ -249,11 +338,15 @@ func GetAll[T Table](page *Page) ([]T, error) {
 		op.Limit = &ps
 		op.Skip = &skip
 	}
-	return result, database.QueryAllByCondition(r.TableName(), bson.M{}, op, &result)
+	return queryAll[T](ctx, database, scopeExcludeDeleted, bson.M{}, op)
 }
 
 func GetAllByCond[T Table](cond map[string]interface{}, page *Page) ([]T, error) {
-	database, err := GetDefaultManager().GetDatabase()
+	return GetAllByCondContext[T](context.TODO(), cond, page)
+}
+
+func GetAllByCondContext[T Table](ctx context.Context, cond map[string]interface{}, page *Page) ([]T, error) {
+	database, err := defaultDatabase[T](ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -265,95 +358,105 @@ func GetAllByCond[T Table](cond map[string]interface{}, page *Page) ([]T, error)
 		op.Limit = &ps
 		op.Skip = &skip
 	}
-	var result []T
-	var r T
-	return result, database.QueryAllByCondition(r.TableName(), c, op, &result)
+	return queryAll[T](ctx, database, scopeExcludeDeleted, c, op)
 }
 
 func GetByCond[T Table](cond bson.M, op *options.FindOptions) (T, error) {
+	return GetByCondContext[T](context.TODO(), cond, op)
+}
+
+func GetByCondContext[T Table](ctx context.Context, cond bson.M, op *options.FindOptions) (T, error) {
 	var r T
-	result, err := QueryByCond[T](cond, op)
+	result, err := QueryByCondContext[T](ctx, cond, op)
 	if err != nil {
-		return r, nil
+		return r, err
 	}
 
 	if len(result) == 0 {
-		return r, nil
+		return r, ErrorDocumentNotFound
 	}
 	return result[0], nil
 }
 
 func GetById[T Table](id string) (T, error) {
-	var r T
-	oid, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return r, err
-	}
-	result, err := QueryByCond[T](bson.M{
-		"_id": oid,
-	}, &options.FindOptions{})
+	return GetByIdContext[T](context.TODO(), id)
+}
+
+func GetByIdContext[T Table](ctx context.Context, id string) (T, error) {
+	database, err := defaultDatabase[T](ctx)
 	if err != nil {
+		var r T
 		return r, err
 	}
-
-	if len(result) == 0 {
-		var z T
-		///return r, ErrorDocumentNotFound
-		return z, nil
-	}
-	return result[0], nil
+	return queryById[T](ctx, database, scopeExcludeDeleted, id)
 }
 
 func Update[T Table](doc T) error {
-	database, err := GetDefaultManager().GetDatabase()
+	return UpdateContext(context.TODO(), doc)
+}
+
+func UpdateContext[T Table](ctx context.Context, doc T) error {
+	database, err := defaultDatabase[T](ctx)
 	if err != nil {
 		return err
 	}
-	//oid, _ := primitive.ObjectIDFromHex(doc.PrimaryKey())
-	return database.Update(doc, bson.M{"_id": doc.PrimaryKey()})
+	if err := prepareUpdate(ctx, doc, &doc); err != nil {
+		return err
+	}
+	return updateDocument(database, doc)
 }
 
 func UpdateAll[T Table](tables []Table) (int64, int64, error) {
-	database, err := GetDefaultManager().GetDatabase()
+	return UpdateAllContext[T](context.TODO(), tables)
+}
+
+func UpdateAllContext[T Table](ctx context.Context, tables []Table) (int64, int64, error) {
+	database, err := defaultDatabase[T](ctx)
 	if err != nil {
 		return 0, 0, err
 	}
 	var r T
-	var writers []mongo.WriteModel
+	tables = addressableAll(tables)
 	for _, table := range tables {
-		writers = append(writers, mongo.NewReplaceOneModel().SetFilter(bson.M{table.PrimaryKeyName(): table.PrimaryKey()}).SetReplacement(table).SetUpsert(true))
+		if err := prepareUpdate(ctx, table, nil); err != nil {
+			return 0, 0, err
+		}
 	}
-	result, err := database.GetRaw().Collection(r.TableName()).BulkWrite(context.TODO(), writers, options.BulkWrite().SetOrdered(false))
-	if err != nil {
-		return 0, 0, err
-	}
-	return result.ModifiedCount, result.InsertedCount, nil
+	return replaceAll(ctx, database, r.TableName(), tables)
 }
 
 func UpdateSet[T Table](cond bson.M, setter bson.M) error {
-	database, err := GetDefaultManager().GetDatabase()
+	return UpdateSetContext[T](context.TODO(), cond, setter)
+}
+
+func UpdateSetContext[T Table](ctx context.Context, cond bson.M, setter bson.M) error {
+	database, err := defaultDatabase[T](ctx)
 	if err != nil {
 		return err
 	}
-	var r T
-	if _, err := database.GetRaw().Collection(r.TableName()).UpdateOne(context.TODO(), cond, setter); err != nil {
-		return err
-	}
-	return nil
+	return updateSet[T](ctx, database, cond, setter)
 }
 
 func Delete[T Table](ids ...string) error {
-	var r T
-	r.TableName()
-	database, err := GetDefaultManager().GetDatabase()
+	return DeleteContext[T](context.TODO(), ids...)
+}
+
+func DeleteContext[T Table](ctx context.Context, ids ...string) error {
+	database, err := defaultDatabase[T](ctx)
 	if err != nil {
 		return err
 	}
-	var oid []primitive.ObjectID
-	for _, id := range ids {
-		o, _ := primitive.ObjectIDFromHex(id)
-		oid = append(oid, o)
+	return deleteByIds[T](ctx, database, ids, false)
+}
+
+func Aggregate[T Table](pipeline mongo.Pipeline) ([]bson.M, error) {
+	return AggregateContext[T](context.TODO(), pipeline)
+}
+
+func AggregateContext[T Table](ctx context.Context, pipeline mongo.Pipeline) ([]bson.M, error) {
+	database, err := defaultDatabase[T](ctx)
+	if err != nil {
+		return nil, err
 	}
-	_, err = database.GetRaw().Collection(r.TableName()).DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$in": oid}})
-	return err
+	return aggregate[T](ctx, database, scopeExcludeDeleted, pipeline)
 }
@@ -7,11 +7,19 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
 )
 
 type MongodbGenericComplex[T Table] struct {
-	writer *MongodbDatabase
-	reader *MongodbDatabase
+	writer  *MongodbDatabase
+	readers *ReaderPool
+	scope   deleteScope
+	ctx     context.Context
+	// window 读己之写窗口期,0 表示不开启
+	window time.Duration
+	// tracker 未绑定 ctx 时使用的写入跟踪
+	tracker *writeTracker
+	stale   bool
 }
 
 func GetGenericComplexDatabase[T Table](writerId string, readerId string) (*MongodbGenericComplex[T], error) {
@@ -20,34 +28,76 @@ func GetGenericComplexDatabase[T Table](writerId string, readerId string) (*Mong
 		return nil, err
 	}
 
-	reader, err := GetDefaultManager().GetDatabaseById(readerId)
-	if nil != err {
+	readers := NewReaderPool()
+	if err := readers.AddReader(readerId, 1); nil != err {
 		return nil, err
 	}
 
 	return &MongodbGenericComplex[T]{
-		writer: writer,
-		reader: reader,
+		writer:  writer,
+		readers: readers,
 	}, nil
 }
 
+func (i *MongodbGenericComplex[T]) WithContext(ctx context.Context) *MongodbGenericComplex[T] {
+	g := *i
+	if ctx != nil && i.window > 0 {
+		ctx = WithReadYourWrites(ctx)
+	}
+	g.ctx = ctx
+	g.writer = i.writer.WithContext(ctx)
+	return &g
+}
+
+// readNode 查询使用的读库,返回 nil 时从写库读取:处于写库的事务中(保证读到事务内的写入)、
+// 开启读己之写且窗口期内有过写入(AllowStale 的查询除外)或没有可用的读库
+func (i *MongodbGenericComplex[T]) readNode() *readerNode {
+	if inTransaction(i.ctx, i.writer.GetRaw().Client()) {
+		return nil
+	}
+	if !i.stale && i.window > 0 && i.writes().writtenWithin(i.window) {
+		return nil
+	}
+	return i.readers.pick()
+}
+
+func (i *MongodbGenericComplex[T]) getCtx() context.Context {
+	if i.ctx == nil {
+		return context.TODO()
+	}
+	return i.ctx
+}
+
 func (i *MongodbGenericComplex[T]) GetWriterRaw() *mongo.Database {
 	return i.writer.GetRaw()
 }
+
+// GetReaderRaw 按负载均衡选择一个可用的读库,没有可用读库时返回写库
 func (i *MongodbGenericComplex[T]) GetReaderRaw() *mongo.Database {
-	return i.reader.GetRaw()
+	if node := i.readers.pick(); node != nil {
+		return node.database.GetRaw()
+	}
+	return i.writer.GetRaw()
 }
 
 func (i *MongodbGenericComplex[T]) Count(filter bson.M) (int64, error) {
-	var r T
-	return i.reader.GetCountByCondition(r.TableName(), filter)
+	return read(i, func(database *MongodbDatabase) (int64, error) {
+		return countDocuments[T](i.getCtx(), database, i.scope, filter)
+	})
 }
 
 func (i *MongodbGenericComplex[T]) InsertAll(tables []interface{}) (int, []interface{}, error) {
 	var r T
-	result, err := i.writer.GetRaw().Collection(r.TableName()).InsertMany(context.TODO(), tables)
+	defer i.markWrite()
+	tables = addressableAll(tables)
+	for _, table := range tables {
+		if err := prepareInsert(i.getCtx(), table, nil); err != nil {
+			return 0, nil, err
+		}
+	}
+	result, err := i.writer.insertMany(i.getCtx(), r.TableName(), tables)
 	if err != nil {
-		return 0, nil, err
+		return 0, nil, wrapError(err)
 	}
 	totalCount := len(tables)
 	insertCount := len(result.InsertedIDs)
@@ -59,6 +109,11 @@ func (i *MongodbGenericComplex[T]) InsertAll(tables []interface{}) (int, []inter
 }
 
 func (i *MongodbGenericComplex[T]) Insert(table Table) (string, error) {
+	defer i.markWrite()
+	table = addressable(table)
+	if err := prepareInsert(i.getCtx(), table, nil); err != nil {
+		return "", err
+	}
 	instanceId, err := i.writer.Save(table)
 	if err != nil {
 		return "", err
@@ -76,16 +131,17 @@ func (i *MongodbGenericComplex[T]) Insert(table Table) (string, error) {
 	return "", nil
 }
 
+func (i *MongodbGenericComplex[T]) queryAll(cond interface{}, op *options.FindOptions) ([]T, error) {
+	return read(i, func(database *MongodbDatabase) ([]T, error) {
+		return queryAll[T](i.getCtx(), database, i.scope, cond, op)
+	})
+}
+
 func (i *MongodbGenericComplex[T]) QueryByCond(cond interface{}, op *options.FindOptions) ([]T, error) {
-	var result []T
-	var r T
-	return result, i.reader.QueryAllByCondition(r.TableName(), cond, op, &result)
+	return i.queryAll(cond, op)
 }
 
 func (i *MongodbGenericComplex[T]) GetAll(page *Page) ([]T, error) {
-	var result []T
-	var r T
-
 	op := &options.FindOptions{}
 	if nil != page && page.Page > 0 && page.PageSize > 0 {
 		ps := int64(page.PageSize)
@@ -93,7 +149,7 @@ func (i *MongodbGenericComplex[T]) GetAll(page *Page) ([]T, error) {
 		op.Limit = &ps
 		op.Skip = &skip
 	}
-	return result, i.reader.QueryAllByCondition(r.TableName(), bson.M{}, op, &result)
+	return i.queryAll(bson.M{}, op)
 }
 
 func (i *MongodbGenericComplex[T]) GetAllByCond(cond map[string]interface{}, page *Page) ([]T, error) {
@@ -105,78 +161,57 @@ func (i *MongodbGenericComplex[T]) GetAllByCond(cond map[string]interface{}, pag
 		op.Limit = &ps
 		op.Skip = &skip
 	}
-	var result []T
-	var r T
-	return result, i.reader.QueryAllByCondition(r.TableName(), c, op, &result)
+	return i.queryAll(c, op)
 }
 
 func (i *MongodbGenericComplex[T]) GetByCond(cond bson.M, op *options.FindOptions) (T, error) {
 	var r T
 	result, err := i.QueryByCond(cond, op)
 	if err != nil {
-		return r, nil
+		return r, err
 	}
 
 	if len(result) == 0 {
-		return r, nil
+		return r, ErrorDocumentNotFound
 	}
 	return result[0], nil
 }
 
 func (i *MongodbGenericComplex[T]) GetById(id string) (T, error) {
-	var r T
-	oid, _ := primitive.ObjectIDFromHex(id)
-	result, err := i.QueryByCond(bson.M{
-		"_id": oid,
-	}, &options.FindOptions{})
-	if err != nil {
-		return r, err
-	}
-
-	if len(result) == 0 {
-		var z T
-		return z, nil
-	}
-	return result[0], nil
+	return read(i, func(database *MongodbDatabase) (T, error) {
+		return queryById[T](i.getCtx(), database, i.scope, id)
+	})
 }
 
 func (i *MongodbGenericComplex[T]) Update(doc T) error {
-	database, err := GetDefaultManager().GetDatabase()
-	if err != nil {
+	defer i.markWrite()
+	if err := prepareUpdate(i.getCtx(), doc, &doc); err != nil {
 		return err
 	}
-	//oid, _ := primitive.ObjectIDFromHex(doc.PrimaryKey())
-	return database.Update(doc, bson.M{"_id": doc.PrimaryKey()})
+	return updateDocument(i.writer, doc)
 }
 
 func (i *MongodbGenericComplex[T]) UpdateAll(tables []T) (int64, int64, error) {
 	var r T
-	var writers []mongo.WriteModel
-	for _, table := range tables {
-		writers = append(writers, mongo.NewReplaceOneModel().SetFilter(bson.M{table.PrimaryKeyName(): table.PrimaryKey()}).SetReplacement(table).SetUpsert(true))
-	}
-	result, err := i.writer.GetRaw().Collection(r.TableName()).BulkWrite(context.TODO(), writers, options.BulkWrite().SetOrdered(false))
-	if err != nil {
+	defer i.markWrite()
+	if err := prepareUpdateAll(i.getCtx(), tables); err != nil {
 		return 0, 0, err
 	}
-	return result.ModifiedCount, result.InsertedCount, nil
+	return replaceAll(i.getCtx(), i.writer, r.TableName(), asTables(tables))
 }
 
 func (i *MongodbGenericComplex[T]) UpdateSet(cond bson.M, setter bson.M) error {
-	var r T
-	if _, err := i.writer.GetRaw().Collection(r.TableName()).UpdateOne(context.TODO(), cond, setter); err != nil {
-		return err
-	}
-	return nil
+	defer i.markWrite()
+	return updateSet[T](i.getCtx(), i.writer, cond, setter)
 }
 
 func (i *MongodbGenericComplex[T]) Delete(ids ...string) error {
-	var r T
-	var oid []primitive.ObjectID
-	for _, id := range ids {
-		o, _ := primitive.ObjectIDFromHex(id)
-		oid = append(oid, o)
-	}
-	_, err := i.writer.GetRaw().Collection(r.TableName()).DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$in": oid}})
-	return err
+	defer i.markWrite()
+	return deleteByIds[T](i.getCtx(), i.writer, ids, false)
+}
+
+func (i *MongodbGenericComplex[T]) Aggregate(pipeline mongo.Pipeline) ([]bson.M, error) {
+	return read(i, func(database *MongodbDatabase) ([]bson.M, error) {
+		return aggregate[T](i.getCtx(), database, i.scope, pipeline)
+	})
 }
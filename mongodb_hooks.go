@@ -0,0 +1,148 @@
+package mongokits
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+)
+
+// BeforeInsertHook 写入前调用,返回错误时终止写入
+type BeforeInsertHook interface {
+	BeforeInsert(ctx context.Context) error
+}
+
+// BeforeUpdateHook 整文档更新(Update/UpdateAll/ReplaceOne)前调用,返回错误时终止更新
+type BeforeUpdateHook interface {
+	BeforeUpdate(ctx context.Context) error
+}
+
+// BeforeDeleteHook 删除前对每个待删除的文档调用,返回错误时终止删除
+type BeforeDeleteHook interface {
+	BeforeDelete(ctx context.Context) error
+}
+
+// AfterFindHook 查询结果解码后调用,返回错误时查询返回该错误
+type AfterFindHook interface {
+	AfterFind(ctx context.Context) error
+}
+
+// lookupHook 先按值查找钩子,再按指针查找,兼容值类型 T 的指针接收者方法
+func lookupHook[H any](doc interface{}, ptr interface{}) (H, bool) {
+	if h, ok := doc.(H); ok {
+		return h, true
+	}
+	if ptr != nil {
+		if h, ok := ptr.(H); ok {
+			return h, true
+		}
+	}
+	var zero H
+	return zero, false
+}
+
+// addressable 值类型的文档复制为指针,使指针接收者实现的钩子、Versioned、Timestamped 等生效,写入的是复制后的文档
+func addressable(table Table) Table {
+	value := reflect.ValueOf(table)
+	if !value.IsValid() || value.Kind() == reflect.Ptr {
+		return table
+	}
+	ptr := reflect.New(value.Type())
+	ptr.Elem().Set(value)
+	return ptr.Interface().(Table)
+}
+
+// addressableAll 返回 docs 的副本,其中值类型的文档已通过 addressable 转换为指针
+func addressableAll[E any](docs []E) []E {
+	result := make([]E, len(docs))
+	for index, doc := range docs {
+		result[index] = doc
+		if table, ok := interface{}(doc).(Table); ok {
+			result[index] = interface{}(addressable(table)).(E)
+		}
+	}
+	return result
+}
+
+// copyDocument 指针类型的文档复制一份结构体,钩子与填充的字段只作用于副本,嵌套的指针、切片、map 仍与原文档共享
+func copyDocument[T any](doc T) T {
+	value := reflect.ValueOf(doc)
+	if !value.IsValid() || value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return doc
+	}
+	ptr := reflect.New(value.Elem().Type())
+	ptr.Elem().Set(value.Elem())
+	return ptr.Interface().(T)
+}
+
+func hasHook[T Table, H any]() bool {
+	var r T
+	_, ok := lookupHook[H](r, &r)
+	return ok
+}
+
+func beforeInsert(ctx context.Context, doc interface{}, ptr interface{}) error {
+	if h, ok := lookupHook[BeforeInsertHook](doc, ptr); ok {
+		return h.BeforeInsert(ctx)
+	}
+	return nil
+}
+
+func beforeUpdate(ctx context.Context, doc interface{}, ptr interface{}) error {
+	if h, ok := lookupHook[BeforeUpdateHook](doc, ptr); ok {
+		return h.BeforeUpdate(ctx)
+	}
+	return nil
+}
+
+func beforeInsertAll[T Table](ctx context.Context, docs []T) error {
+	for index := range docs {
+		if h, ok := lookupHook[BeforeInsertHook](docs[index], &docs[index]); ok {
+			if err := h.BeforeInsert(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func beforeUpdateAll[T Table](ctx context.Context, docs []T) error {
+	for index := range docs {
+		if h, ok := lookupHook[BeforeUpdateHook](docs[index], &docs[index]); ok {
+			if err := h.BeforeUpdate(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func afterFind[T Table](ctx context.Context, docs []T) error {
+	for index := range docs {
+		if h, ok := lookupHook[AfterFindHook](docs[index], &docs[index]); ok {
+			if err := h.AfterFind(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// beforeDelete 加载待删除的文档并逐个调用 BeforeDelete,未实现钩子时不会查询,opts 用于只删除一个文档时限制数量
+func beforeDelete[T Table](ctx context.Context, database *MongodbDatabase, filter interface{}, opts ...*options.FindOptions) error {
+	if !hasHook[T, BeforeDeleteHook]() {
+		return nil
+	}
+	var r T
+	var docs []T
+	if err := database.QueryAllByCondition(r.TableName(), filter, options.MergeFindOptions(opts...), &docs); err != nil {
+		return err
+	}
+	for index := range docs {
+		if h, ok := lookupHook[BeforeDeleteHook](docs[index], &docs[index]); ok {
+			if err := h.BeforeDelete(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
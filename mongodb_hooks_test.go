@@ -0,0 +1,107 @@
+package mongokits
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// stampedItem 值类型的表,钩子、Versioned 与 Timestamped 均以指针接收者实现
+type stampedItem struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	Name      string             `bson:"name"`
+	Slug      string             `bson:"slug"`
+	Version   int64              `bson:"version"`
+	CreatedAt time.Time          `bson:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+func (s stampedItem) TableName() string       { return "test_stamped_items" }
+func (s stampedItem) PrimaryKey() interface{} { return s.Id }
+func (s stampedItem) PrimaryKeyName() string  { return "_id" }
+
+func (s *stampedItem) BeforeInsert(ctx context.Context) error {
+	s.Slug = strings.ToLower(s.Name)
+	return nil
+}
+
+func (s *stampedItem) VersionField() string     { return "version" }
+func (s *stampedItem) GetVersion() int64        { return s.Version }
+func (s *stampedItem) SetVersion(version int64) { s.Version = version }
+
+func (s *stampedItem) TimestampFields() (string, string) { return "created_at", "updated_at" }
+func (s *stampedItem) GetCreatedAt() time.Time           { return s.CreatedAt }
+func (s *stampedItem) SetCreatedAt(t time.Time)          { s.CreatedAt = t }
+func (s *stampedItem) SetUpdatedAt(t time.Time)          { s.UpdatedAt = t }
+
+func TestPrepareValueDocument(t *testing.T) {
+	ctx := context.Background()
+	single := addressable(stampedItem{Name: "Single"})
+	if err := prepareInsert(ctx, single, nil); err != nil {
+		t.Fatal(err)
+	}
+	batch := []stampedItem{{Name: "Batch"}}
+	if err := prepareInsertAll(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range []stampedItem{*single.(*stampedItem), batch[0]} {
+		if doc.Slug != strings.ToLower(doc.Name) || doc.CreatedAt.IsZero() || doc.UpdatedAt.IsZero() {
+			t.Fatalf("insert hooks not applied to %+v", doc)
+		}
+	}
+
+	doc := stampedItem{Name: "Update"}
+	if err := prepareUpdate(ctx, doc, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.UpdatedAt.IsZero() {
+		t.Fatal("update timestamp not applied to a value document")
+	}
+}
+
+// 值类型文档经 Insert 与 InsertMany 写入的结果一致,Update 检查并递增版本号
+func TestValueDocumentInsertAndUpdate(t *testing.T) {
+	openTestDatabase(t, stampedItem{}.TableName())
+	repo, err := GetGenericDatabase[stampedItem]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	single := stampedItem{Id: primitive.NewObjectID(), Name: "Single"}
+	if _, err := repo.Insert(single); err != nil {
+		t.Fatal(err)
+	}
+	batch := []stampedItem{{Id: primitive.NewObjectID(), Name: "Batch"}}
+	if _, err := repo.InsertMany(batch); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []primitive.ObjectID{single.Id, batch[0].Id} {
+		doc, err := repo.GetById(id.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.Slug != strings.ToLower(doc.Name) || doc.CreatedAt.IsZero() {
+			t.Fatalf("insert hooks not applied to %+v", doc)
+		}
+	}
+
+	doc, err := repo.GetById(single.Id.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Update(doc); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := repo.GetById(single.Id.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Version != doc.Version+1 {
+		t.Fatalf("update should bump the version, got %+v", stored)
+	}
+	if err := repo.Update(doc); !IsVersionConflict(err) {
+		t.Fatalf("stale update should conflict, got %v", err)
+	}
+}
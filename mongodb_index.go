@@ -0,0 +1,438 @@
+package mongokits
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Indexed Table 实现该接口声明集合需要的索引,由 EnsureIndexes 创建缺失的索引
+type Indexed interface {
+	Indexes() []*IndexSpec
+}
+
+type IndexSpec struct {
+	name            string
+	keys            bson.D
+	unique          bool
+	sparse          bool
+	expireAfter     *int32
+	partial         bson.M
+	collation       *options.Collation
+	weights         bson.M
+	defaultLanguage string
+}
+
+// NewIndex 普通索引/复合索引,字段名以 - 开头表示降序
+func NewIndex(fields ...string) *IndexSpec {
+	spec := &IndexSpec{}
+	for _, field := range fields {
+		if strings.HasPrefix(field, "-") {
+			spec.keys = append(spec.keys, bson.E{Key: field[1:], Value: -1})
+		} else {
+			spec.keys = append(spec.keys, bson.E{Key: field, Value: 1})
+		}
+	}
+	return spec
+}
+
+// NewTextIndex 全文索引
+func NewTextIndex(fields ...string) *IndexSpec {
+	spec := &IndexSpec{}
+	for _, field := range fields {
+		spec.keys = append(spec.keys, bson.E{Key: field, Value: "text"})
+	}
+	return spec
+}
+
+// NewGeoIndex 2dsphere 地理位置索引
+func NewGeoIndex(field string) *IndexSpec {
+	return &IndexSpec{keys: bson.D{{Key: field, Value: "2dsphere"}}}
+}
+
+func (spec *IndexSpec) Name(name string) *IndexSpec {
+	spec.name = name
+	return spec
+}
+
+func (spec *IndexSpec) Unique() *IndexSpec {
+	spec.unique = true
+	return spec
+}
+
+func (spec *IndexSpec) Sparse() *IndexSpec {
+	spec.sparse = true
+	return spec
+}
+
+// TTL 文档在索引字段时间之后 expireAfter 过期删除
+func (spec *IndexSpec) TTL(expireAfter time.Duration) *IndexSpec {
+	seconds := int32(expireAfter / time.Second)
+	spec.expireAfter = &seconds
+	return spec
+}
+
+func (spec *IndexSpec) Partial(filter bson.M) *IndexSpec {
+	spec.partial = filter
+	return spec
+}
+
+func (spec *IndexSpec) Collation(collation *options.Collation) *IndexSpec {
+	spec.collation = collation
+	return spec
+}
+
+func (spec *IndexSpec) Weights(weights bson.M) *IndexSpec {
+	spec.weights = weights
+	return spec
+}
+
+func (spec *IndexSpec) DefaultLanguage(language string) *IndexSpec {
+	spec.defaultLanguage = language
+	return spec
+}
+
+// IndexName 未指定名称时与服务端默认规则一致,如 email_1、title_text_body_text
+func (spec *IndexSpec) IndexName() string {
+	if spec.name != "" {
+		return spec.name
+	}
+	var parts []string
+	for _, key := range spec.keys {
+		parts = append(parts, fmt.Sprintf("%s_%v", key.Key, key.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+func (spec *IndexSpec) isText() bool {
+	for _, key := range spec.keys {
+		if key.Value == "text" {
+			return true
+		}
+	}
+	return false
+}
+
+func (spec *IndexSpec) model() mongo.IndexModel {
+	op := options.Index().SetName(spec.IndexName())
+	if spec.unique {
+		op.SetUnique(true)
+	}
+	if spec.sparse {
+		op.SetSparse(true)
+	}
+	if spec.expireAfter != nil {
+		op.SetExpireAfterSeconds(*spec.expireAfter)
+	}
+	if spec.partial != nil {
+		op.SetPartialFilterExpression(spec.partial)
+	}
+	if spec.collation != nil {
+		op.SetCollation(spec.collation)
+	}
+	if spec.weights != nil {
+		op.SetWeights(spec.weights)
+	}
+	if spec.defaultLanguage != "" {
+		op.SetDefaultLanguage(spec.defaultLanguage)
+	}
+	return mongo.IndexModel{Keys: spec.keys, Options: op}
+}
+
+func (spec *IndexSpec) String() string {
+	var opts []string
+	if spec.unique {
+		opts = append(opts, "unique")
+	}
+	if spec.sparse {
+		opts = append(opts, "sparse")
+	}
+	if spec.expireAfter != nil {
+		opts = append(opts, fmt.Sprintf("ttl=%ds", *spec.expireAfter))
+	}
+	if spec.partial != nil {
+		opts = append(opts, fmt.Sprintf("partial=%v", spec.partial))
+	}
+	if spec.collation != nil {
+		opts = append(opts, fmt.Sprintf("collation=%s/%d", spec.collation.Locale, spec.collation.Strength))
+	}
+	return fmt.Sprintf("%s %v %s", spec.IndexName(), spec.keys, strings.Join(opts, ","))
+}
+
+type existingIndex struct {
+	Name               string      `bson:"name"`
+	Key                bson.D      `bson:"key"`
+	Unique             bool        `bson:"unique"`
+	Sparse             bool        `bson:"sparse"`
+	ExpireAfterSeconds interface{} `bson:"expireAfterSeconds"`
+	Partial            bson.M      `bson:"partialFilterExpression"`
+	Collation          bson.M      `bson:"collation"`
+	Weights            bson.M      `bson:"weights"`
+	DefaultLanguage    string      `bson:"default_language"`
+}
+
+// matches 判断已存在的索引与声明是否一致
+func (spec *IndexSpec) matches(index existingIndex) bool {
+	if spec.unique != index.Unique || spec.sparse != index.Sparse {
+		return false
+	}
+	if spec.isText() {
+		weights := spec.weights
+		if weights == nil {
+			weights = bson.M{}
+			for _, key := range spec.keys {
+				weights[key.Key] = 1
+			}
+		}
+		if !sameValue(weights, index.Weights) {
+			return false
+		}
+		if spec.defaultLanguage != "" && spec.defaultLanguage != index.DefaultLanguage {
+			return false
+		}
+	} else if !sameValue(spec.keys, index.Key) {
+		return false
+	}
+	if (spec.expireAfter == nil) != (index.ExpireAfterSeconds == nil) {
+		return false
+	}
+	if spec.expireAfter != nil && !sameValue(*spec.expireAfter, index.ExpireAfterSeconds) {
+		return false
+	}
+	if !sameValue(spec.partial, index.Partial) {
+		return false
+	}
+	if (spec.collation == nil) != (index.Collation == nil) {
+		return false
+	}
+	if spec.collation != nil {
+		if spec.collation.Locale != index.Collation["locale"] {
+			return false
+		}
+		if spec.collation.Strength != 0 && !sameValue(spec.collation.Strength, index.Collation["strength"]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameValue 比较 bson 值,数值统一按 float64 比较,文档忽略字段顺序(索引 key 除外)
+func sameValue(a interface{}, b interface{}) bool {
+	return reflect.DeepEqual(normalizeValue(a), normalizeValue(b))
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case int:
+		return float64(value)
+	case int32:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case float32:
+		return float64(value)
+	case bson.D:
+		if len(value) == 0 {
+			return nil
+		}
+		result := make([]interface{}, 0, len(value)*2)
+		for _, e := range value {
+			result = append(result, e.Key, normalizeValue(e.Value))
+		}
+		return result
+	case bson.M:
+		if len(value) == 0 {
+			return nil
+		}
+		result := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			result[k] = normalizeValue(item)
+		}
+		return result
+	case bson.A:
+		result := make([]interface{}, len(value))
+		for index, item := range value {
+			result[index] = normalizeValue(item)
+		}
+		return result
+	}
+	return v
+}
+
+/*
+*
+IndexPlan 索引变更计划,Rebuild 为同名但定义不一致的索引,Obsolete 为未声明的索引
+Conflicts 为未开启 DropObsolete 而未重建的 Rebuild 索引,Applied 表示已执行变更且声明的索引全部与定义一致,存在 Conflicts 时为 false
+*/
+type IndexPlan struct {
+	Table     string
+	Create    []*IndexSpec
+	Rebuild   []*IndexSpec
+	Obsolete  []string
+	Unchanged []string
+	Conflicts []*IndexSpec
+	Applied   bool
+}
+
+func (p *IndexPlan) Empty() bool {
+	return len(p.Create) == 0 && len(p.Rebuild) == 0 && len(p.Obsolete) == 0
+}
+
+func (p *IndexPlan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "indexes of %s:\n", p.Table)
+	for _, spec := range p.Create {
+		fmt.Fprintf(&b, "  + %s\n", spec)
+	}
+	for _, spec := range p.Rebuild {
+		fmt.Fprintf(&b, "  ~ %s\n", spec)
+	}
+	for _, name := range p.Obsolete {
+		fmt.Fprintf(&b, "  - %s\n", name)
+	}
+	for _, name := range p.Unchanged {
+		fmt.Fprintf(&b, "  = %s\n", name)
+	}
+	for _, spec := range p.Conflicts {
+		fmt.Fprintf(&b, "  ! %s (not rebuilt without DropObsolete)\n", spec)
+	}
+	return b.String()
+}
+
+type EnsureIndexesOptions struct {
+	dryRun       bool
+	dropObsolete bool
+}
+
+func NewEnsureIndexesOptions() *EnsureIndexesOptions {
+	return &EnsureIndexesOptions{}
+}
+
+// DryRun 只生成变更计划,不修改索引
+func (op *EnsureIndexesOptions) DryRun(dryRun bool) *EnsureIndexesOptions {
+	op.dryRun = dryRun
+	return op
+}
+
+// DropObsolete 删除未声明的索引并重建定义不一致的索引
+func (op *EnsureIndexesOptions) DropObsolete(drop bool) *EnsureIndexesOptions {
+	op.dropObsolete = drop
+	return op
+}
+
+// PlanIndexes 对比声明的索引与集合现有索引,生成变更计划
+func (i *MongodbDatabase) PlanIndexes(tableName string, specs []*IndexSpec) (*IndexPlan, error) {
+	ctx := i.client.GetCtx()
+	cursor, err := i.listIndexes(ctx, tableName)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	var existing []existingIndex
+	if err := cursor.All(ctx, &existing); err != nil {
+		return nil, wrapError(err)
+	}
+
+	plan := &IndexPlan{Table: tableName}
+	declared := make(map[string]bool)
+	found := make(map[string]existingIndex)
+	for _, index := range existing {
+		found[index.Name] = index
+	}
+	for _, spec := range specs {
+		name := spec.IndexName()
+		declared[name] = true
+		index, exists := found[name]
+		switch {
+		case !exists:
+			plan.Create = append(plan.Create, spec)
+		case spec.matches(index):
+			plan.Unchanged = append(plan.Unchanged, name)
+		default:
+			plan.Rebuild = append(plan.Rebuild, spec)
+		}
+	}
+	for _, index := range existing {
+		if index.Name != "_id_" && !declared[index.Name] {
+			plan.Obsolete = append(plan.Obsolete, index.Name)
+		}
+	}
+	return plan, nil
+}
+
+// EnsureIndexes 创建缺失的索引,DropObsolete 时删除未声明的索引并重建不一致的索引,DryRun 时只返回计划
+func (i *MongodbDatabase) EnsureIndexes(tableName string, specs []*IndexSpec, ops ...*EnsureIndexesOptions) (*IndexPlan, error) {
+	op := NewEnsureIndexesOptions()
+	if len(ops) > 0 && ops[0] != nil {
+		op = ops[0]
+	}
+	plan, err := i.PlanIndexes(tableName, specs)
+	if err != nil || op.dryRun || plan.Empty() {
+		return plan, err
+	}
+
+	ctx := i.client.GetCtx()
+	if op.dropObsolete {
+		for _, name := range plan.Obsolete {
+			if err := i.dropIndex(ctx, tableName, name); err != nil {
+				return plan, wrapError(err)
+			}
+		}
+		for _, spec := range plan.Rebuild {
+			if err := i.dropIndex(ctx, tableName, spec.IndexName()); err != nil {
+				return plan, wrapError(err)
+			}
+			if err := i.createIndexes(ctx, tableName, []mongo.IndexModel{spec.model()}); err != nil {
+				return plan, wrapError(err)
+			}
+		}
+	}
+	if len(plan.Create) > 0 {
+		var models []mongo.IndexModel
+		for _, spec := range plan.Create {
+			models = append(models, spec.model())
+		}
+		if err := i.createIndexes(ctx, tableName, models); err != nil {
+			return plan, wrapError(err)
+		}
+	}
+	if !op.dropObsolete {
+		plan.Conflicts = plan.Rebuild
+	}
+	plan.Applied = len(plan.Conflicts) == 0
+	return plan, nil
+}
+
+func tableIndexes[T Table]() []*IndexSpec {
+	var r T
+	if indexed, ok := lookupHook[Indexed](r, &r); ok {
+		return indexed.Indexes()
+	}
+	return nil
+}
+
+func (i *MongodbGeneric[T]) EnsureIndexes(ops ...*EnsureIndexesOptions) (*IndexPlan, error) {
+	var r T
+	return i.database.EnsureIndexes(r.TableName(), tableIndexes[T](), ops...)
+}
+
+func (i *MongodbGenericComplex[T]) EnsureIndexes(ops ...*EnsureIndexesOptions) (*IndexPlan, error) {
+	var r T
+	return i.writer.EnsureIndexes(r.TableName(), tableIndexes[T](), ops...)
+}
+
+// EnsureIndexes 按 T 声明的索引同步 T 所在数据源(Datasourced,默认为默认数据源)中的集合索引
+func EnsureIndexes[T Table](ops ...*EnsureIndexesOptions) (*IndexPlan, error) {
+	var r T
+	database, err := tableDatabase(context.TODO(), r, &r)
+	if err != nil {
+		return nil, err
+	}
+	return database.EnsureIndexes(r.TableName(), tableIndexes[T](), ops...)
+}
@@ -0,0 +1,35 @@
+package mongokits
+
+import "testing"
+
+// 定义变化的索引未开启 DropObsolete 时不重建,计划报告冲突且不视为已应用
+func TestEnsureIndexesConflict(t *testing.T) {
+	database := openTestDatabase(t, "test_indexed")
+	if _, err := database.EnsureIndexes("test_indexed", []*IndexSpec{NewIndex("email")}); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := []*IndexSpec{NewIndex("email").Unique()}
+	plan, err := database.EnsureIndexes("test_indexed", changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Applied || len(plan.Conflicts) != 1 || plan.Conflicts[0].IndexName() != "email_1" {
+		t.Fatalf("changed index should be reported as a conflict, got %s", plan)
+	}
+
+	plan, err = database.EnsureIndexes("test_indexed", changed, NewEnsureIndexesOptions().DropObsolete(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Applied || len(plan.Conflicts) != 0 {
+		t.Fatalf("changed index should be rebuilt with DropObsolete, got %s", plan)
+	}
+	plan, err = database.PlanIndexes("test_indexed", changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Rebuild) != 0 || len(plan.Unchanged) != 1 {
+		t.Fatalf("index should match the declaration after rebuilding, got %s", plan)
+	}
+}
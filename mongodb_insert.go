@@ -0,0 +1,309 @@
+package mongokits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"sync"
+)
+
+type InsertManyOptions struct {
+	batchCount  int
+	batchBytes  int
+	concurrency int
+	ordered     bool
+}
+
+func NewInsertManyOptions() *InsertManyOptions {
+	return &InsertManyOptions{
+		batchCount:  1000,
+		batchBytes:  defaultBulkBatchBytes,
+		concurrency: 1,
+		ordered:     true,
+	}
+}
+
+// BatchCount 单批次最多写入的文档数量
+func (op *InsertManyOptions) BatchCount(count int) *InsertManyOptions {
+	if count > 0 && count <= defaultBulkBatchCount {
+		op.batchCount = count
+	}
+	return op
+}
+
+// BatchBytes 单批次文档的最大字节数
+func (op *InsertManyOptions) BatchBytes(size int) *InsertManyOptions {
+	if size > 0 && size <= defaultBulkBatchBytes {
+		op.batchBytes = size
+	}
+	return op
+}
+
+// Concurrency 批次并发写入数量,仅在无序写入(Ordered(false))时生效;默认有序写入,此时按顺序逐批写入并输出 WARN 日志
+// 事务中会话不支持并发使用,同样按顺序写入
+func (op *InsertManyOptions) Concurrency(concurrency int) *InsertManyOptions {
+	if concurrency > 0 {
+		op.concurrency = concurrency
+	}
+	return op
+}
+
+// Ordered 有序写入时遇到失败即停止,后续文档不再写入
+func (op *InsertManyOptions) Ordered(ordered bool) *InsertManyOptions {
+	op.ordered = ordered
+	return op
+}
+
+// InsertManyResult 批量写入结果,Written 为成功写入的文档下标,Ids 与 Written 一一对应
+// 已写入但主键无法转换为 K 的文档记录在 Failed 中,Err 为转换错误
+type InsertManyResult[K any] struct {
+	Ids     []K
+	Written []int
+	Failed  []BulkItemResult
+}
+
+func (i *MongodbGeneric[T]) InsertMany(docs []T, ops ...*InsertManyOptions) (*InsertManyResult[string], error) {
+	defer i.invalidate()
+	return insertMany[T, string](i.getCtx(), i.database, docs, ops...)
+}
+
+func (i *MongodbGenericComplex[T]) InsertMany(docs []T, ops ...*InsertManyOptions) (*InsertManyResult[string], error) {
+	defer i.markWrite()
+	return insertMany[T, string](i.getCtx(), i.writer, docs, ops...)
+}
+
+// InsertMany 类型安全的批量新增,按数量和大小分批写入,K 为主键类型
+func InsertMany[T Table, K any](docs []T, ops ...*InsertManyOptions) (*InsertManyResult[K], error) {
+	return InsertManyContext[T, K](context.TODO(), docs, ops...)
+}
+
+func InsertManyContext[T Table, K any](ctx context.Context, docs []T, ops ...*InsertManyOptions) (*InsertManyResult[K], error) {
+	database, err := defaultDatabase[T](ctx)
+	if err != nil {
+		return nil, err
+	}
+	return insertMany[T, K](ctx, database, docs, ops...)
+}
+
+func insertMany[T Table, K any](ctx context.Context, database *MongodbDatabase, docs []T, ops ...*InsertManyOptions) (*InsertManyResult[K], error) {
+	var r T
+	if err := prepareInsertAll(ctx, docs); err != nil {
+		return nil, err
+	}
+	return insertManyInto[T, K](ctx, database, r.TableName(), docs, ops...)
+}
+
+// insertManyInto 分批写入指定的集合,调用方需已执行 prepareInsertAll
+func insertManyInto[T Table, K any](ctx context.Context, database *MongodbDatabase, tableName string, docs []T, ops ...*InsertManyOptions) (*InsertManyResult[K], error) {
+	if len(docs) == 0 {
+		return nil, mongo.ErrEmptySlice
+	}
+	op := NewInsertManyOptions()
+	if len(ops) > 0 && ops[0] != nil {
+		op = ops[0]
+	}
+	ids := make([]interface{}, len(docs))
+	errs := make([]error, len(docs))
+	for index := range errs {
+		errs[index] = ErrorBulkNotExecuted
+	}
+
+	// 各批次写入的下标互不重叠,并发写入 ids/errs 无需加锁
+	runBatch := func(batch []int) bool {
+		items := make([]interface{}, len(batch))
+		for pos, index := range batch {
+			items[pos] = docs[index]
+		}
+		res, err := database.insertMany(ctx, tableName, items, options.InsertMany().SetOrdered(op.ordered))
+		return applyInsertResult(batch, res, err, op.ordered, ids, errs)
+	}
+
+	batches := splitInsertBatches(docs, op)
+	if op.ordered && op.concurrency > 1 && len(batches) > 1 {
+		database.client.log(ctx, LogWarn, "mongodb insertMany concurrency ignored for ordered writes",
+			LogField{Key: LogFieldCollection, Value: tableName})
+	}
+	// 会话不支持并发使用,事务中按顺序写入
+	if op.ordered || op.concurrency <= 1 || inTransaction(ctx, database.GetRaw().Client()) {
+		for _, batch := range batches {
+			if !runBatch(batch) && op.ordered {
+				break
+			}
+		}
+	} else {
+		var wg sync.WaitGroup
+		limit := make(chan struct{}, op.concurrency)
+		for _, batch := range batches {
+			wg.Add(1)
+			limit <- struct{}{}
+			go func(batch []int) {
+				defer wg.Done()
+				defer func() { <-limit }()
+				runBatch(batch)
+			}(batch)
+		}
+		wg.Wait()
+	}
+
+	result := &InsertManyResult[K]{}
+	for index, err := range errs {
+		if err != nil {
+			result.Failed = append(result.Failed, BulkItemResult{Index: index, Type: BulkInsertOne, Err: err})
+			continue
+		}
+		key, err := convertKey[K](ids[index])
+		if err != nil {
+			// 文档已写入,只是主键无法转换为 K,记录后继续处理其余文档
+			result.Failed = append(result.Failed, BulkItemResult{Index: index, Type: BulkInsertOne, Err: err})
+			continue
+		}
+		result.Written = append(result.Written, index)
+		result.Ids = append(result.Ids, key)
+	}
+	if len(result.Failed) > 0 {
+		return result, &BulkError{Total: len(docs), Failed: result.Failed}
+	}
+	return result, nil
+}
+
+// insertGroups 按 group 将文档分组后分别写入,合并各组结果,结果中的下标对应 docs 的原始下标
+func insertGroups[T Table](docs []T, group func(doc T) (string, error), insert func(key string, part []T) (*InsertManyResult[string], error)) (*InsertManyResult[string], error) {
+	groups := make(map[string][]int)
+	var order []string
+	for index, doc := range docs {
+		key, err := group(doc)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := groups[key]; !exists {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], index)
+	}
+
+	result := &InsertManyResult[string]{}
+	var firstErr error
+	for _, key := range order {
+		indexes := groups[key]
+		part := make([]T, len(indexes))
+		for pos, index := range indexes {
+			part[pos] = docs[index]
+		}
+		res, err := insert(key, part)
+		// 写回钩子与时间戳对值类型文档的修改
+		for pos, index := range indexes {
+			docs[index] = part[pos]
+		}
+		if res == nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for pos, written := range res.Written {
+			result.Written = append(result.Written, indexes[written])
+			result.Ids = append(result.Ids, res.Ids[pos])
+		}
+		for _, item := range res.Failed {
+			item.Index = indexes[item.Index]
+			result.Failed = append(result.Failed, item)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if len(result.Failed) > 0 {
+		sort.Slice(result.Failed, func(x, y int) bool {
+			return result.Failed[x].Index < result.Failed[y].Index
+		})
+		return result, &BulkError{Total: len(docs), Failed: result.Failed}
+	}
+	return result, firstErr
+}
+
+func splitInsertBatches[T Table](docs []T, op *InsertManyOptions) [][]int {
+	var batches [][]int
+	var current []int
+	currentSize := 0
+	for index, doc := range docs {
+		size := bsonSize(doc)
+		if len(current) > 0 && (len(current) >= op.batchCount || currentSize+size > op.batchBytes) {
+			batches = append(batches, current)
+			current = nil
+			currentSize = 0
+		}
+		current = append(current, index)
+		currentSize += size
+	}
+	if len(current) > 0 {
+		batches = append(batches, current)
+	}
+	return batches
+}
+
+// applyInsertResult 将批次结果写回原始下标,返回该批次是否全部成功
+func applyInsertResult(batch []int, res *mongo.InsertManyResult, err error, ordered bool, ids []interface{}, errs []error) bool {
+	var bwe mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bwe) {
+		for _, index := range batch {
+			errs[index] = wrapError(err)
+		}
+		return false
+	}
+
+	firstFailure := len(batch)
+	itemErrors := make(map[int]error)
+	for _, we := range bwe.WriteErrors {
+		if we.Index >= 0 && we.Index < len(batch) {
+			itemErrors[we.Index] = wrapError(we.WriteError)
+			if we.Index < firstFailure {
+				firstFailure = we.Index
+			}
+		}
+	}
+	for pos, index := range batch {
+		switch {
+		case itemErrors[pos] != nil:
+			errs[index] = itemErrors[pos]
+		case ordered && pos > firstFailure:
+			errs[index] = ErrorBulkNotExecuted
+		case bwe.WriteConcernError != nil:
+			errs[index] = wrapError(*bwe.WriteConcernError)
+		default:
+			errs[index] = nil
+			if res != nil && pos < len(res.InsertedIDs) {
+				ids[index] = res.InsertedIDs[pos]
+			}
+		}
+	}
+	return err == nil
+}
+
+// convertKey 将写入返回的 _id 转换为指定的主键类型,ObjectID 与十六进制字符串可互相转换
+func convertKey[K any](id interface{}) (K, error) {
+	var key K
+	if v, ok := id.(K); ok {
+		return v, nil
+	}
+	switch k := interface{}(&key).(type) {
+	case *string:
+		if oid, ok := id.(primitive.ObjectID); ok {
+			*k = oid.Hex()
+			return key, nil
+		}
+	case *primitive.ObjectID:
+		if s, ok := id.(string); ok {
+			oid, err := parseObjectId(s)
+			if err != nil {
+				return key, err
+			}
+			*k = oid
+			return key, nil
+		}
+	}
+	return key, fmt.Errorf("inserted id %v can not convert to %T", id, key)
+}
@@ -0,0 +1,74 @@
+package mongokits
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 无序写入时批次并发执行,有序写入时逐批执行
+func TestInsertManyConcurrency(t *testing.T) {
+	for _, c := range []struct {
+		ordered bool
+		want    int32
+	}{{false, 4}, {true, 1}} {
+		var active, peak int32
+		database := newFakeDatabase(t, func(ctx context.Context, op *Operation, next Invoker) error {
+			current := atomic.AddInt32(&active, 1)
+			defer atomic.AddInt32(&active, -1)
+			for {
+				max := atomic.LoadInt32(&peak)
+				if current <= max || atomic.CompareAndSwapInt32(&peak, max, current) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			documents := op.Document.([]interface{})
+			result := &mongo.InsertManyResult{}
+			for _, document := range documents {
+				result.InsertedIDs = append(result.InsertedIDs, document.(*testItem).Id)
+			}
+			op.Result = result
+			return nil
+		})
+
+		docs := make([]*testItem, 40)
+		for index := range docs {
+			docs[index] = &testItem{Id: primitive.NewObjectID()}
+		}
+		result, err := insertMany[*testItem, primitive.ObjectID](context.Background(), database, docs,
+			NewInsertManyOptions().BatchCount(5).Concurrency(4).Ordered(c.ordered))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Written) != len(docs) {
+			t.Fatalf("ordered=%v wrote %d/%d", c.ordered, len(result.Written), len(docs))
+		}
+		if peak != c.want {
+			t.Fatalf("ordered=%v ran %d batches in parallel, want %d", c.ordered, peak, c.want)
+		}
+	}
+}
+
+// 主键无法转换为 K 时记录该文档,其余文档的结果照常返回
+func TestInsertManyKeyConversion(t *testing.T) {
+	database := newFakeDatabase(t, func(ctx context.Context, op *Operation, next Invoker) error {
+		op.Result = &mongo.InsertManyResult{InsertedIDs: []interface{}{primitive.NewObjectID(), 7, primitive.NewObjectID()}}
+		return nil
+	})
+	docs := []*testItem{{Id: primitive.NewObjectID()}, {}, {Id: primitive.NewObjectID()}}
+	result, err := insertMany[*testItem, string](context.Background(), database, docs)
+	if err == nil {
+		t.Fatal("expected a conversion failure")
+	}
+	if len(result.Written) != 2 || result.Written[0] != 0 || result.Written[1] != 2 || len(result.Ids) != 2 {
+		t.Fatalf("written %v ids %v", result.Written, result.Ids)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Index != 1 || result.Failed[0].Err == ErrorBulkNotExecuted {
+		t.Fatalf("failed %+v", result.Failed)
+	}
+}
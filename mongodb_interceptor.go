@@ -0,0 +1,324 @@
+package mongokits
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sync"
+	"time"
+)
+
+// 操作名,与驱动的命令名一致
+const (
+	OpFind              = "find"
+	OpFindOne           = "findOne"
+	OpFindOneAndReplace = "findOneAndReplace"
+	OpCount             = "count"
+	OpAggregate         = "aggregate"
+	OpInsertOne         = "insertOne"
+	OpInsertMany        = "insertMany"
+	OpUpdateOne         = "updateOne"
+	OpUpdateMany        = "updateMany"
+	OpDeleteOne         = "deleteOne"
+	OpDeleteMany        = "deleteMany"
+	OpBulkWrite         = "bulkWrite"
+	OpCommand           = "command"
+	OpListCollections   = "listCollections"
+	OpDrop              = "drop"
+	OpListIndexes       = "listIndexes"
+	OpCreateIndexes     = "createIndexes"
+	OpDropIndexes       = "dropIndexes"
+	// OpWatch 打开变更流,Collection 为空时监听整个数据库;之后读取事件的 getMore 不经过拦截器
+	OpWatch = "watch"
+)
+
+/*
+*
+Operation 一次数据库操作,拦截器可在调用 next 前修改 Filter、Update、Document、Pipeline 与 Options
+Result 对查询为解码的目标(指针),对写入为驱动返回的结果;拦截器不调用 next 直接返回时需自行填充 Result
+Duration 为驱动调用的耗时,在 next 返回后可读
+*/
+type Operation struct {
+	Datasource string
+	Database   string
+	Collection string
+	Name       string
+	Filter     interface{}
+	Update     interface{}
+	Document   interface{}
+	Pipeline   interface{}
+	Options    interface{}
+	Result     interface{}
+	Start      time.Time
+	Duration   time.Duration
+}
+
+// Invoker 执行操作,ctx 与 op 可能已被前面的拦截器替换或修改
+type Invoker func(ctx context.Context, op *Operation) error
+
+/*
+*
+Interceptor 包裹数据库操作,调用 next 继续执行,不调用 next 时操作被短路
+全局拦截器(UseInterceptors)在数据源拦截器(MongoOptions.Interceptors)之外,按注册顺序由外到内执行
+以下驱动调用不经过拦截器:读库健康检查的 ping、事务的开始/提交/回滚、游标与变更流后续批次的 getMore
+*/
+type Interceptor func(ctx context.Context, op *Operation, next Invoker) error
+
+var globalInterceptors struct {
+	mutex sync.RWMutex
+	list  []Interceptor
+}
+
+// UseInterceptors 注册作用于所有数据源的拦截器
+func UseInterceptors(interceptors ...Interceptor) {
+	globalInterceptors.mutex.Lock()
+	defer globalInterceptors.mutex.Unlock()
+	globalInterceptors.list = append(globalInterceptors.list, interceptors...)
+}
+
+func (client *MongoClient) interceptors() []Interceptor {
+	globalInterceptors.mutex.RLock()
+	list := append([]Interceptor(nil), globalInterceptors.list...)
+	globalInterceptors.mutex.RUnlock()
+	if client.options != nil {
+		list = append(list, client.options.interceptors...)
+	}
+	return list
+}
+
+// intercept 经拦截器链执行 invoke
+func (client *MongoClient) intercept(ctx context.Context, op *Operation, invoke Invoker) error {
+	if client.options != nil {
+		op.Datasource = client.options.Id
+	}
+	op.Database = client.database.Name()
+	op.Start = time.Now()
+	if err := transactionClient(ctx, client.database.Client()); err != nil {
+		return err
+	}
+	handler := func(ctx context.Context, op *Operation) error {
+		start := time.Now()
+		err := invoke(ctx, op)
+		op.Duration = time.Since(start)
+		client.logOperation(ctx, op, err)
+		client.reportSlow(ctx, op, err)
+		return err
+	}
+	return chainInterceptors(client.interceptors(), handler)(ctx, op)
+}
+
+func chainInterceptors(interceptors []Interceptor, invoke Invoker) Invoker {
+	for index := len(interceptors) - 1; index >= 0; index-- {
+		interceptor, next := interceptors[index], invoke
+		invoke = func(ctx context.Context, op *Operation) error {
+			return interceptor(ctx, op, next)
+		}
+	}
+	return invoke
+}
+
+// intercept 直接使用驱动集合的操作经数据源的拦截器链执行
+func (i *MongodbDatabase) intercept(ctx context.Context, op *Operation, invoke Invoker) error {
+	return i.client.intercept(ctx, op, invoke)
+}
+
+func (i *MongodbDatabase) insertOne(ctx context.Context, collection string, document interface{}) (*mongo.InsertOneResult, error) {
+	op := &Operation{Collection: collection, Name: OpInsertOne, Document: document}
+	err := i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		result, err := i.GetRaw().Collection(op.Collection).InsertOne(ctx, op.Document)
+		op.Result = result
+		return err
+	})
+	result, _ := op.Result.(*mongo.InsertOneResult)
+	return result, err
+}
+
+func (i *MongodbDatabase) insertMany(ctx context.Context, collection string, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	op := &Operation{Collection: collection, Name: OpInsertMany, Document: documents, Options: options.MergeInsertManyOptions(opts...)}
+	err := i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		documents, _ := op.Document.([]interface{})
+		insertOption, _ := op.Options.(*options.InsertManyOptions)
+		result, err := i.GetRaw().Collection(op.Collection).InsertMany(ctx, documents, insertOption)
+		op.Result = result
+		return err
+	})
+	result, _ := op.Result.(*mongo.InsertManyResult)
+	return result, err
+}
+
+func (i *MongodbDatabase) updateOne(ctx context.Context, collection string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	op := &Operation{Collection: collection, Name: OpUpdateOne, Filter: filter, Update: update, Options: options.MergeUpdateOptions(opts...)}
+	err := i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		updateOption, _ := op.Options.(*options.UpdateOptions)
+		result, err := i.GetRaw().Collection(op.Collection).UpdateOne(ctx, op.Filter, op.Update, updateOption)
+		op.Result = result
+		return err
+	})
+	result, _ := op.Result.(*mongo.UpdateResult)
+	return result, err
+}
+
+func (i *MongodbDatabase) updateMany(ctx context.Context, collection string, filter interface{}, update interface{}) (*mongo.UpdateResult, error) {
+	op := &Operation{Collection: collection, Name: OpUpdateMany, Filter: filter, Update: update}
+	err := i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		result, err := i.GetRaw().Collection(op.Collection).UpdateMany(ctx, op.Filter, op.Update)
+		op.Result = result
+		return err
+	})
+	result, _ := op.Result.(*mongo.UpdateResult)
+	return result, err
+}
+
+func (i *MongodbDatabase) deleteOne(ctx context.Context, collection string, filter interface{}) (*mongo.DeleteResult, error) {
+	op := &Operation{Collection: collection, Name: OpDeleteOne, Filter: filter}
+	err := i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		result, err := i.GetRaw().Collection(op.Collection).DeleteOne(ctx, op.Filter)
+		op.Result = result
+		return err
+	})
+	result, _ := op.Result.(*mongo.DeleteResult)
+	return result, err
+}
+
+func (i *MongodbDatabase) deleteMany(ctx context.Context, collection string, filter interface{}) (*mongo.DeleteResult, error) {
+	op := &Operation{Collection: collection, Name: OpDeleteMany, Filter: filter}
+	err := i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		result, err := i.GetRaw().Collection(op.Collection).DeleteMany(ctx, op.Filter)
+		op.Result = result
+		return err
+	})
+	result, _ := op.Result.(*mongo.DeleteResult)
+	return result, err
+}
+
+// bulkWrite 出错时 BulkWriteException 对应的部分结果仍在返回值中
+func (i *MongodbDatabase) bulkWrite(ctx context.Context, collection string, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	op := &Operation{Collection: collection, Name: OpBulkWrite, Document: models, Options: options.MergeBulkWriteOptions(opts...)}
+	err := i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		models, _ := op.Document.([]mongo.WriteModel)
+		bulkOption, _ := op.Options.(*options.BulkWriteOptions)
+		result, err := i.GetRaw().Collection(op.Collection).BulkWrite(ctx, models, bulkOption)
+		op.Result = result
+		return err
+	})
+	result, _ := op.Result.(*mongo.BulkWriteResult)
+	return result, err
+}
+
+// findOne 没有匹配的文档时返回 mongo.ErrNoDocuments
+func (i *MongodbDatabase) findOne(ctx context.Context, collection string, filter interface{}, result interface{}) error {
+	op := &Operation{Collection: collection, Name: OpFindOne, Filter: filter, Result: result}
+	return i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		return i.GetRaw().Collection(op.Collection).FindOne(ctx, op.Filter).Decode(op.Result)
+	})
+}
+
+func (i *MongodbDatabase) find(ctx context.Context, collection string, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	op := &Operation{Collection: collection, Name: OpFind, Filter: filter, Options: options.MergeFindOptions(opts...)}
+	err := i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		cursor, err := i.GetRaw().Collection(op.Collection).Find(ctx, op.Filter, findOptions(op))
+		op.Result = cursor
+		return err
+	})
+	cursor, _ := op.Result.(*mongo.Cursor)
+	return cursor, err
+}
+
+func (i *MongodbDatabase) aggregate(ctx context.Context, collection string, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	op := &Operation{Collection: collection, Name: OpAggregate, Pipeline: pipeline, Options: options.MergeAggregateOptions(opts...)}
+	err := i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		aggregateOption, _ := op.Options.(*options.AggregateOptions)
+		cursor, err := i.GetRaw().Collection(op.Collection).Aggregate(ctx, op.Pipeline, aggregateOption)
+		op.Result = cursor
+		return err
+	})
+	cursor, _ := op.Result.(*mongo.Cursor)
+	return cursor, err
+}
+
+func (i *MongodbDatabase) runCommand(ctx context.Context, command interface{}) error {
+	op := &Operation{Name: OpCommand, Document: command}
+	return i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		raw, err := i.GetRaw().RunCommand(ctx, op.Document).DecodeBytes()
+		op.Result = raw
+		return err
+	})
+}
+
+func (i *MongodbDatabase) listCollections(ctx context.Context, filter interface{}) (*mongo.Cursor, error) {
+	op := &Operation{Name: OpListCollections, Filter: filter}
+	err := i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		cursor, err := i.GetRaw().ListCollections(ctx, op.Filter)
+		op.Result = cursor
+		return err
+	})
+	cursor, _ := op.Result.(*mongo.Cursor)
+	return cursor, err
+}
+
+func (i *MongodbDatabase) listCollectionNames(ctx context.Context, filter interface{}) ([]string, error) {
+	op := &Operation{Name: OpListCollections, Filter: filter}
+	err := i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		names, err := i.GetRaw().ListCollectionNames(ctx, op.Filter)
+		op.Result = names
+		return err
+	})
+	names, _ := op.Result.([]string)
+	return names, err
+}
+
+func (i *MongodbDatabase) dropCollection(ctx context.Context, collection string) error {
+	op := &Operation{Collection: collection, Name: OpDrop}
+	return i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		return i.GetRaw().Collection(op.Collection).Drop(ctx)
+	})
+}
+
+func (i *MongodbDatabase) listIndexes(ctx context.Context, collection string) (*mongo.Cursor, error) {
+	op := &Operation{Collection: collection, Name: OpListIndexes}
+	err := i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		cursor, err := i.GetRaw().Collection(op.Collection).Indexes().List(ctx)
+		op.Result = cursor
+		return err
+	})
+	cursor, _ := op.Result.(*mongo.Cursor)
+	return cursor, err
+}
+
+func (i *MongodbDatabase) createIndexes(ctx context.Context, collection string, models []mongo.IndexModel) error {
+	op := &Operation{Collection: collection, Name: OpCreateIndexes, Document: models}
+	return i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		models, _ := op.Document.([]mongo.IndexModel)
+		names, err := i.GetRaw().Collection(op.Collection).Indexes().CreateMany(ctx, models)
+		op.Result = names
+		return err
+	})
+}
+
+func (i *MongodbDatabase) dropIndex(ctx context.Context, collection string, name string) error {
+	op := &Operation{Collection: collection, Name: OpDropIndexes, Document: name}
+	return i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		name, _ := op.Document.(string)
+		_, err := i.GetRaw().Collection(op.Collection).Indexes().DropOne(ctx, name)
+		return err
+	})
+}
+
+// watch collection 为空时监听整个数据库
+func (i *MongodbDatabase) watch(ctx context.Context, collection string, pipeline interface{}, opts ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error) {
+	op := &Operation{Collection: collection, Name: OpWatch, Pipeline: pipeline, Options: options.MergeChangeStreamOptions(opts...)}
+	err := i.intercept(ctx, op, func(ctx context.Context, op *Operation) error {
+		streamOption, _ := op.Options.(*options.ChangeStreamOptions)
+		var stream *mongo.ChangeStream
+		var err error
+		if op.Collection == "" {
+			stream, err = i.GetRaw().Watch(ctx, op.Pipeline, streamOption)
+		} else {
+			stream, err = i.GetRaw().Collection(op.Collection).Watch(ctx, op.Pipeline, streamOption)
+		}
+		op.Result = stream
+		return err
+	})
+	stream, _ := op.Result.(*mongo.ChangeStream)
+	return stream, err
+}
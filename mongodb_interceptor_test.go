@@ -0,0 +1,74 @@
+package mongokits
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var errShortCircuit = errors.New("short circuit")
+
+// newRecordingDatabase 拦截器记录操作名并直接返回,不访问服务器
+func newRecordingDatabase(t *testing.T) (*MongodbDatabase, *[]string) {
+	t.Helper()
+	var names []string
+	database := newFakeDatabase(t, func(ctx context.Context, op *Operation, next Invoker) error {
+		names = append(names, op.Name)
+		return errShortCircuit
+	})
+	return database, &names
+}
+
+func TestInterceptAuxiliaryOperations(t *testing.T) {
+	cases := []struct {
+		name string
+		call func(database *MongodbDatabase) error
+		want []string
+	}{
+		{"ApplyValidator", func(database *MongodbDatabase) error {
+			return database.ApplyValidator("items", bson.M{}, ValidationLevelStrict, ValidationActionError)
+		}, []string{OpListCollections}},
+		{"GetValidator", func(database *MongodbDatabase) error {
+			_, err := database.GetValidator("items")
+			return err
+		}, []string{OpListCollections}},
+		{"EnsureIndexes", func(database *MongodbDatabase) error {
+			_, err := database.EnsureIndexes("items", nil)
+			return err
+		}, []string{OpListIndexes}},
+		{"WatchTable", func(database *MongodbDatabase) error {
+			_, err := database.WatchTable("items", NewWatchOptions().Checkpoint("consumer"))
+			return err
+		}, []string{OpFindOne}},
+		{"Watch", func(database *MongodbDatabase) error {
+			_, err := database.Watch()
+			return err
+		}, []string{OpWatch}},
+		{"MigratorStatus", func(database *MongodbDatabase) error {
+			_, err := NewMigratorWithDatabase(database).Status()
+			return err
+		}, []string{OpFind}},
+		{"RelayOnce", func(database *MongodbDatabase) error {
+			relay := NewOutboxRelay(database, PublisherFunc(func(ctx context.Context, event *OutboxEvent) error { return nil }))
+			_, err := relay.RelayOnce(context.Background())
+			return err
+		}, []string{OpAggregate}},
+		{"FindOne", func(database *MongodbDatabase) error {
+			return database.client.FindOne("items", bson.M{}, &bson.M{})
+		}, []string{OpFindOne}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			database, names := newRecordingDatabase(t)
+			if err := c.call(database); !errors.Is(err, errShortCircuit) {
+				t.Fatalf("expected the interceptor error, got %v", err)
+			}
+			if !reflect.DeepEqual(*names, c.want) {
+				t.Fatalf("intercepted %v, want %v", *names, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package mongokits
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// 日志字段名
+const (
+	LogFieldDatasource = "datasource"
+	LogFieldDatabase   = "database"
+	LogFieldCollection = "collection"
+	LogFieldOp         = "op"
+	LogFieldDuration   = "duration"
+	LogFieldError      = "error"
+	LogFieldErrorKind  = "error_kind"
+	LogFieldErrorCode  = "error_code"
+	LogFieldFilter     = "filter"
+	LogFieldServer     = "server"
+)
+
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+/*
+*
+Logger 结构化日志接口,Enabled 为 false 时不构造字段,go1.21 及以上可使用 NewSlogLogger 适配 log/slog
+默认不输出任何日志,通过 SetLogger 设置全局日志,MongoOptions.Logger 设置数据源日志
+*/
+type Logger interface {
+	Enabled(ctx context.Context, level LogLevel) bool
+	Log(ctx context.Context, level LogLevel, msg string, fields ...LogField)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Enabled(context.Context, LogLevel) bool { return false }
+
+func (nopLogger) Log(context.Context, LogLevel, string, ...LogField) {}
+
+var globalLogger struct {
+	mutex  sync.RWMutex
+	logger Logger
+}
+
+// SetLogger 设置全局日志,未设置日志的数据源与配置解析使用该日志,nil 表示不输出
+func SetLogger(logger Logger) {
+	globalLogger.mutex.Lock()
+	defer globalLogger.mutex.Unlock()
+	globalLogger.logger = logger
+}
+
+func defaultLogger() Logger {
+	globalLogger.mutex.RLock()
+	defer globalLogger.mutex.RUnlock()
+	if globalLogger.logger == nil {
+		return nopLogger{}
+	}
+	return globalLogger.logger
+}
+
+func (client *MongoClient) logger() Logger {
+	if client.options != nil && client.options.logger != nil {
+		return client.options.logger
+	}
+	return defaultLogger()
+}
+
+// log 输出带数据源字段的日志
+func (client *MongoClient) log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	logger := client.logger()
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	base := []LogField{{Key: LogFieldDatasource, Value: client.datasourceId()}}
+	logger.Log(ctx, level, msg, append(base, fields...)...)
+}
+
+func (client *MongoClient) datasourceId() string {
+	if client.options == nil {
+		return ""
+	}
+	return client.options.Id
+}
+
+// logOperation 操作成功或未找到文档时输出 DEBUG,其他错误输出 ERROR
+func (client *MongoClient) logOperation(ctx context.Context, op *Operation, err error) {
+	level := LogDebug
+	if err != nil && !errors.Is(wrapError(err), ErrorDocumentNotFound) {
+		level = LogError
+	}
+	if !client.logger().Enabled(ctx, level) {
+		return
+	}
+	fields := []LogField{
+		{Key: LogFieldDatabase, Value: op.Database},
+		{Key: LogFieldCollection, Value: op.Collection},
+		{Key: LogFieldOp, Value: op.Name},
+		{Key: LogFieldDuration, Value: op.Duration},
+	}
+	if op.Filter != nil {
+		fields = append(fields, LogField{Key: LogFieldFilter, Value: client.redactFilter(op.Filter)})
+	}
+	if err != nil {
+		fields = append(fields, errorFields(err)...)
+	}
+	client.log(ctx, level, "mongodb operation", fields...)
+}
+
+// errorFields 错误的类型、服务端错误码与隐藏了键值的错误信息,原始错误中可能包含文档的值(如唯一索引冲突的 dup key)
+func errorFields(err error) []LogField {
+	wrapped := wrapError(err)
+	var fields []LogField
+	var kind *KindError
+	var dup *DuplicateKeyError
+	switch {
+	case errors.As(wrapped, &dup):
+		fields = append(fields, LogField{Key: LogFieldErrorKind, Value: ErrorDuplicateKey.Error()})
+	case errors.As(wrapped, &kind):
+		fields = append(fields, LogField{Key: LogFieldErrorKind, Value: kind.Kind.Error()})
+	}
+	if code, ok := errorCode(err); ok {
+		fields = append(fields, LogField{Key: LogFieldErrorCode, Value: code})
+	}
+	return append(fields, LogField{Key: LogFieldError, Value: RedactError(err)})
+}
+
+// errorCode 驱动错误的服务端错误码,写入错误取第一个
+func errorCode(err error) (int, bool) {
+	var writeException mongo.WriteException
+	var bulkException mongo.BulkWriteException
+	var commandError mongo.CommandError
+	var writeError mongo.WriteError
+	var writeConcernError mongo.WriteConcernError
+	switch {
+	case errors.As(err, &writeException):
+		if len(writeException.WriteErrors) > 0 {
+			return writeException.WriteErrors[0].Code, true
+		}
+		if writeException.WriteConcernError != nil {
+			return writeException.WriteConcernError.Code, true
+		}
+	case errors.As(err, &bulkException):
+		if len(bulkException.WriteErrors) > 0 {
+			return bulkException.WriteErrors[0].Code, true
+		}
+		if bulkException.WriteConcernError != nil {
+			return bulkException.WriteConcernError.Code, true
+		}
+	case errors.As(err, &commandError):
+		return int(commandError.Code), true
+	case errors.As(err, &writeError):
+		return writeError.Code, true
+	case errors.As(err, &writeConcernError):
+		return writeConcernError.Code, true
+	}
+	return 0, false
+}
+
+const dupKeyPrefix = "dup key: {"
+
+// RedactError 返回隐藏了唯一索引冲突键值(dup key)的错误信息,批量写入的错误信息中可能包含多个 dup key
+func RedactError(err error) string {
+	if err == nil {
+		return ""
+	}
+	message := err.Error()
+	var builder strings.Builder
+	for {
+		start := strings.Index(message, dupKeyPrefix)
+		if start < 0 {
+			builder.WriteString(message)
+			return builder.String()
+		}
+		builder.WriteString(message[:start])
+		builder.WriteString("dup key: { " + redacted + " }")
+		message = message[start+len(dupKeyPrefix)-1+closingBrace(message[start+len(dupKeyPrefix)-1:]):]
+	}
+}
+
+// closingBrace 返回与 s[0] 的 { 配对的 } 之后的下标,忽略字符串中的括号,未配对时返回 len(s)
+func closingBrace(s string) int {
+	depth, quoted, escaped := 0, false, false
+	for index := 0; index < len(s); index++ {
+		switch c := s[index]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return index + 1
+			}
+		}
+	}
+	return len(s)
+}
+
+const redacted = "******"
+
+// 字段名(小写)包含以下内容时视为敏感字段
+var sensitiveFields = []string{"password", "passwd", "pwd", "secret", "token", "credential", "apikey", "api_key", "authorization"}
+
+var (
+	uriUserInfo = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9+.\-]*://[^:@/]*):[^/]*@`)
+	uriPassword = regexp.MustCompile(`(?i)([?&][^=&]*(?:password|secret|token)[^=&]*=)[^&]*`)
+)
+
+// RedactURI 隐藏连接串中的密码与密码类参数
+func RedactURI(uri string) string {
+	uri = uriUserInfo.ReplaceAllString(uri, "${1}:"+redacted+"@")
+	return uriPassword.ReplaceAllString(uri, "${1}"+redacted)
+}
+
+func (client *MongoClient) redactFilter(filter interface{}) interface{} {
+	var fields []string
+	if client.options != nil {
+		fields = client.options.sensitiveFields
+	}
+	return RedactFilter(filter, fields...)
+}
+
+// RedactFilter 返回隐藏了敏感字段值的条件副本,fields 为内置敏感字段之外的字段名,原条件不被修改
+func RedactFilter(filter interface{}, fields ...string) interface{} {
+	return redactValue(filter, func(key string) bool {
+		key = strings.ToLower(key)
+		for _, field := range fields {
+			if strings.ToLower(field) == key {
+				return true
+			}
+		}
+		for _, field := range sensitiveFields {
+			if strings.Contains(key, field) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func redactValue(value interface{}, sensitive func(key string) bool) interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		m := make(bson.M, len(v))
+		for key, item := range v {
+			m[key] = redactEntry(key, item, sensitive)
+		}
+		return m
+	case map[string]interface{}:
+		return redactValue(bson.M(v), sensitive)
+	case bson.D:
+		d := make(bson.D, len(v))
+		for index, e := range v {
+			d[index] = bson.E{Key: e.Key, Value: redactEntry(e.Key, e.Value, sensitive)}
+		}
+		return d
+	case bson.A:
+		a := make(bson.A, len(v))
+		for index, item := range v {
+			a[index] = redactValue(item, sensitive)
+		}
+		return a
+	case []interface{}:
+		return redactValue(bson.A(v), sensitive)
+	case mongo.Pipeline:
+		p := make(mongo.Pipeline, len(v))
+		for index, stage := range v {
+			p[index], _ = redactValue(stage, sensitive).(bson.D)
+		}
+		return p
+	}
+	return value
+}
+
+// redactEntry 敏感字段的值整体隐藏,运算符($and、$in 等)继续向下处理
+func redactEntry(key string, value interface{}, sensitive func(key string) bool) interface{} {
+	if !strings.HasPrefix(key, "$") && sensitive(key) {
+		return redacted
+	}
+	return redactValue(value, sensitive)
+}
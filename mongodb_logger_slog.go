@@ -0,0 +1,45 @@
+//go:build go1.21
+
+package mongokits
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger 使用 log/slog 输出日志,级别过滤由 slog 的 Handler 决定
+func NewSlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return slogLogger{logger: logger}
+}
+
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case LogDebug:
+		return slog.LevelDebug
+	case LogInfo:
+		return slog.LevelInfo
+	case LogWarn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
+
+func (l slogLogger) Enabled(ctx context.Context, level LogLevel) bool {
+	return l.logger.Enabled(ctx, slogLevel(level))
+}
+
+func (l slogLogger) Log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	attrs := make([]slog.Attr, len(fields))
+	for index, field := range fields {
+		attrs[index] = slog.Any(field.Key, field.Value)
+	}
+	l.logger.LogAttrs(ctx, slogLevel(level), msg, attrs...)
+}
@@ -0,0 +1,438 @@
+package mongokits
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMigrationCollection = "schema_migrations"
+	defaultMigrationLease      = time.Minute
+	// 租期下限,避免续租间隔过短
+	minMigrationLease = time.Second
+	migrationLockId   = "lock"
+)
+
+type MigrationFunc func(ctx context.Context, database *MongodbDatabase) error
+
+// Migration 一个版本的迁移,Version 需唯一且大于 0,建议使用时间戳如 20261019120000
+// Down 为空时该版本不支持回滚
+type Migration struct {
+	Version     int64
+	Description string
+	Up          MigrationFunc
+	Down        MigrationFunc
+}
+
+type MigrationStatus struct {
+	Version     int64
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+	// Missing 已记录为执行但未注册的版本
+	Missing bool
+}
+
+type migrationRecord struct {
+	Version     int64     `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+var (
+	migrationMutex sync.Mutex
+	migrations     = make(map[int64]*Migration)
+)
+
+// RegisterMigration 注册全局迁移,通常在 init 中调用,版本号重复时 panic
+func RegisterMigration(migration *Migration) {
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+	if err := checkMigration(migrations, migration); err != nil {
+		panic(err)
+	}
+	migrations[migration.Version] = migration
+}
+
+func checkMigration(registered map[int64]*Migration, migration *Migration) error {
+	if migration == nil || migration.Up == nil {
+		return fmt.Errorf("migration up function required")
+	}
+	if migration.Version <= 0 {
+		return fmt.Errorf("migration version %d must be positive", migration.Version)
+	}
+	if _, exists := registered[migration.Version]; exists {
+		return fmt.Errorf("migration version %d already registered", migration.Version)
+	}
+	return nil
+}
+
+type MigrationOptions struct {
+	collection string
+	lease      time.Duration
+	owner      string
+}
+
+func NewMigrationOptions() *MigrationOptions {
+	return &MigrationOptions{
+		collection: defaultMigrationCollection,
+		lease:      defaultMigrationLease,
+	}
+}
+
+// Collection 记录已执行版本的集合,锁记录在同名加 _lock 后缀的集合中
+func (op *MigrationOptions) Collection(collection string) *MigrationOptions {
+	op.collection = collection
+	return op
+}
+
+// Lease 锁的租期,执行期间每隔租期的三分之一续租,进程异常退出后锁在租期结束后释放
+// 小于等于 0 时忽略,小于 1 秒时按 1 秒处理
+func (op *MigrationOptions) Lease(lease time.Duration) *MigrationOptions {
+	if lease > 0 {
+		op.lease = lease
+	}
+	return op
+}
+
+// Owner 锁持有者标识,默认为主机名加进程号
+func (op *MigrationOptions) Owner(owner string) *MigrationOptions {
+	op.owner = owner
+	return op
+}
+
+type Migrator struct {
+	database   *MongodbDatabase
+	migrations map[int64]*Migration
+	ops        *MigrationOptions
+	ctx        context.Context
+}
+
+// NewMigrator 使用 GetDefaultManager() 中 id 对应的数据源,包含所有通过 RegisterMigration 注册的迁移
+func NewMigrator(id string, ops ...*MigrationOptions) (*Migrator, error) {
+	database, err := GetDefaultManager().GetDatabaseById(id)
+	if err != nil {
+		return nil, err
+	}
+	return NewMigratorWithDatabase(database, ops...), nil
+}
+
+func NewMigratorWithDatabase(database *MongodbDatabase, ops ...*MigrationOptions) *Migrator {
+	op := NewMigrationOptions()
+	if len(ops) > 0 && ops[0] != nil {
+		op = ops[0]
+	}
+	if op.lease <= 0 {
+		op.lease = defaultMigrationLease
+	} else if op.lease < minMigrationLease {
+		op.lease = minMigrationLease
+	}
+	if op.owner == "" {
+		host, _ := os.Hostname()
+		op.owner = fmt.Sprintf("%s-%d-%s", host, os.Getpid(), primitive.NewObjectID().Hex())
+	}
+	m := &Migrator{
+		database:   database,
+		migrations: make(map[int64]*Migration),
+		ops:        op,
+	}
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+	for version, migration := range migrations {
+		m.migrations[version] = migration
+	}
+	return m
+}
+
+// Register 为当前 Migrator 追加迁移,不影响全局注册
+func (m *Migrator) Register(migrations ...*Migration) error {
+	for _, migration := range migrations {
+		if err := checkMigration(m.migrations, migration); err != nil {
+			return err
+		}
+		m.migrations[migration.Version] = migration
+	}
+	return nil
+}
+
+func (m *Migrator) WithContext(ctx context.Context) *Migrator {
+	c := *m
+	c.ctx = ctx
+	return &c
+}
+
+func (m *Migrator) getCtx() context.Context {
+	if m.ctx == nil {
+		return context.TODO()
+	}
+	return m.ctx
+}
+
+func (m *Migrator) versions() []int64 {
+	var versions []int64
+	for version := range m.migrations {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(a, b int) bool { return versions[a] < versions[b] })
+	return versions
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]migrationRecord, error) {
+	cursor, err := m.database.find(ctx, m.ops.collection, bson.M{})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	var records []migrationRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, wrapError(err)
+	}
+	result := make(map[int64]migrationRecord, len(records))
+	for _, record := range records {
+		result[record.Version] = record
+	}
+	return result, nil
+}
+
+// Status 返回所有已注册及已执行版本的状态,按版本号升序
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	applied, err := m.applied(m.getCtx())
+	if err != nil {
+		return nil, err
+	}
+	var result []*MigrationStatus
+	for _, version := range m.versions() {
+		status := &MigrationStatus{Version: version, Description: m.migrations[version].Description}
+		if record, ok := applied[version]; ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+		}
+		result = append(result, status)
+	}
+	for version, record := range applied {
+		if _, ok := m.migrations[version]; !ok {
+			result = append(result, &MigrationStatus{
+				Version:     version,
+				Description: record.Description,
+				Applied:     true,
+				AppliedAt:   record.AppliedAt,
+				Missing:     true,
+			})
+		}
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].Version < result[b].Version })
+	return result, nil
+}
+
+// Current 返回已执行的最大版本号,没有执行过任何迁移时返回 0
+func (m *Migrator) Current() (int64, error) {
+	applied, err := m.applied(m.getCtx())
+	if err != nil {
+		return 0, err
+	}
+	var current int64
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+	return current, nil
+}
+
+// Up 按版本号升序执行所有未执行的迁移,返回本次执行的版本
+func (m *Migrator) Up() ([]int64, error) {
+	return m.run(func(applied map[int64]migrationRecord) ([]int64, []int64) {
+		return nil, m.pending(applied, 0)
+	})
+}
+
+// Down 按版本号降序回滚最近执行的 steps 个版本,steps 小于 1 时回滚一个版本
+func (m *Migrator) Down(steps int) ([]int64, error) {
+	if steps < 1 {
+		steps = 1
+	}
+	return m.run(func(applied map[int64]migrationRecord) ([]int64, []int64) {
+		versions := appliedVersions(applied)
+		if len(versions) > steps {
+			versions = versions[:steps]
+		}
+		return versions, nil
+	})
+}
+
+// To 迁移到指定版本:先回滚大于 version 的已执行迁移,再执行不大于 version 的未执行迁移,version 为 0 时回滚全部
+func (m *Migrator) To(version int64) ([]int64, error) {
+	if _, ok := m.migrations[version]; version != 0 && !ok {
+		return nil, fmt.Errorf("migration version %d not registered", version)
+	}
+	return m.run(func(applied map[int64]migrationRecord) ([]int64, []int64) {
+		var rollback []int64
+		for _, v := range appliedVersions(applied) {
+			if v > version {
+				rollback = append(rollback, v)
+			}
+		}
+		if version == 0 {
+			return rollback, nil
+		}
+		return rollback, m.pending(applied, version)
+	})
+}
+
+// pending 未执行的已注册版本,按版本号升序,limit 大于 0 时只返回不大于 limit 的版本
+func (m *Migrator) pending(applied map[int64]migrationRecord, limit int64) []int64 {
+	var versions []int64
+	for _, version := range m.versions() {
+		if _, ok := applied[version]; !ok && (limit == 0 || version <= limit) {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// appliedVersions 已执行的版本,按版本号降序
+func appliedVersions(applied map[int64]migrationRecord) []int64 {
+	var versions []int64
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(a, b int) bool { return versions[a] > versions[b] })
+	return versions
+}
+
+// run 持有锁后读取已执行版本,由 plan 决定需要回滚与执行的版本,先回滚后执行
+func (m *Migrator) run(plan func(applied map[int64]migrationRecord) (down []int64, up []int64)) ([]int64, error) {
+	ctx, cancel := context.WithCancel(m.getCtx())
+	defer cancel()
+	lost, err := m.lock(ctx, cancel)
+	if err != nil {
+		return nil, err
+	}
+	defer m.unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	down, up := plan(applied)
+	for _, version := range down {
+		if migration, ok := m.migrations[version]; !ok {
+			return nil, fmt.Errorf("migration version %d not registered", version)
+		} else if migration.Down == nil {
+			return nil, fmt.Errorf("migration version %d can not be rolled back", version)
+		}
+	}
+
+	done, err := m.step(ctx, lost, down, false)
+	if err != nil {
+		return done, err
+	}
+	applyDone, err := m.step(ctx, lost, up, true)
+	return append(done, applyDone...), err
+}
+
+// step 逐个执行或回滚迁移,每个版本成功后立即记录
+func (m *Migrator) step(ctx context.Context, lost func() bool, versions []int64, up bool) ([]int64, error) {
+	var done []int64
+	for _, version := range versions {
+		var err error
+		migration := m.migrations[version]
+		if up {
+			err = migration.Up(ctx, m.database)
+		} else {
+			err = migration.Down(ctx, m.database)
+		}
+		if lost() {
+			err = ErrorMigrationLocked
+		}
+		if err != nil {
+			return done, fmt.Errorf("migration version %d: %w", version, err)
+		}
+		if up {
+			record := migrationRecord{Version: version, Description: migration.Description, AppliedAt: time.Now()}
+			_, err = m.database.insertOne(ctx, m.ops.collection, record)
+		} else {
+			_, err = m.database.deleteOne(ctx, m.ops.collection, bson.M{"_id": version})
+		}
+		if err != nil {
+			return done, fmt.Errorf("record migration version %d: %w", version, wrapError(err))
+		}
+		done = append(done, version)
+	}
+	return done, nil
+}
+
+func (m *Migrator) lockCollection() string {
+	return m.ops.collection + "_lock"
+}
+
+// lock 获取租约锁并在后台续租,续租失败时取消 ctx,返回的函数用于判断锁是否已丢失
+func (m *Migrator) lock(ctx context.Context, cancel context.CancelFunc) (func() bool, error) {
+	collection := m.lockCollection()
+	now := time.Now()
+	filter := bson.M{
+		"_id": migrationLockId,
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$lte": now}},
+			bson.M{"owner": m.ops.owner},
+		},
+	}
+	update := bson.M{"$set": bson.M{"owner": m.ops.owner, "acquired_at": now, "expires_at": now.Add(m.ops.lease)}}
+	// 锁被其他持有者占用且未过期时,upsert 会与已存在的 _id 冲突
+	_, err := m.database.updateOne(ctx, collection, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		if err = wrapError(err); IsDuplicateKey(err) {
+			return nil, ErrorMigrationLocked
+		}
+		return nil, err
+	}
+
+	var mutex sync.Mutex
+	lost := false
+	go func() {
+		ticker := time.NewTicker(m.ops.lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				result, err := m.database.updateOne(ctx, collection,
+					bson.M{"_id": migrationLockId, "owner": m.ops.owner},
+					bson.M{"$set": bson.M{"expires_at": time.Now().Add(m.ops.lease)}})
+				if ctx.Err() != nil {
+					return
+				}
+				if err == nil && result.MatchedCount > 0 {
+					continue
+				}
+				mutex.Lock()
+				lost = true
+				mutex.Unlock()
+				cancel()
+				return
+			}
+		}
+	}()
+	return func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return lost
+	}, nil
+}
+
+func (m *Migrator) unlock() {
+	timeout := m.database.client.GetDuration()
+	if timeout <= 0 {
+		timeout = m.ops.lease
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, _ = m.database.deleteOne(ctx, m.lockCollection(), bson.M{"_id": migrationLockId, "owner": m.ops.owner})
+}
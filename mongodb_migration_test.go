@@ -0,0 +1,23 @@
+package mongokits
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMigrationLeaseBounds(t *testing.T) {
+	for _, c := range []struct {
+		ops  *MigrationOptions
+		want time.Duration
+	}{
+		{NewMigrationOptions().Lease(2), minMigrationLease},
+		{NewMigrationOptions().Lease(0), defaultMigrationLease},
+		{NewMigrationOptions().Lease(-time.Second), defaultMigrationLease},
+		{NewMigrationOptions().Lease(5 * time.Second), 5 * time.Second},
+		{&MigrationOptions{collection: defaultMigrationCollection}, defaultMigrationLease},
+	} {
+		if got := NewMigratorWithDatabase(nil, c.ops).ops.lease; got != c.want {
+			t.Errorf("lease = %v, want %v", got, c.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package mongokits
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// commandMonitor 合并数据源注册的命令监听器,未注册时返回 nil
+func commandMonitor(monitors []*event.CommandMonitor) *event.CommandMonitor {
+	if len(monitors) == 0 {
+		return nil
+	}
+	if len(monitors) == 1 {
+		return monitors[0]
+	}
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			for _, m := range monitors {
+				if m.Started != nil {
+					m.Started(ctx, e)
+				}
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			for _, m := range monitors {
+				if m.Succeeded != nil {
+					m.Succeeded(ctx, e)
+				}
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			for _, m := range monitors {
+				if m.Failed != nil {
+					m.Failed(ctx, e)
+				}
+			}
+		},
+	}
+}
+
+// poolMonitor 合并数据源注册的连接池监听器,未注册时返回 nil
+func poolMonitor(monitors []*event.PoolMonitor) *event.PoolMonitor {
+	if len(monitors) == 0 {
+		return nil
+	}
+	if len(monitors) == 1 {
+		return monitors[0]
+	}
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			for _, m := range monitors {
+				if m.Event != nil {
+					m.Event(e)
+				}
+			}
+		},
+	}
+}
@@ -0,0 +1,366 @@
+package mongokits
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"time"
+)
+
+const (
+	outboxCollection      = "outbox"
+	outboxCleanupInterval = time.Minute
+
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	// OutboxDead 超过最大重试次数,不再投递,同一聚合的后续事件继续投递
+	OutboxDead = "dead"
+)
+
+// OutboxEvent 发件箱事件,与业务写入在同一事务中写入 outbox 集合,由 OutboxRelay 投递
+type OutboxEvent struct {
+	Id            primitive.ObjectID `bson:"_id"`
+	AggregateType string             `bson:"aggregate_type"`
+	AggregateId   string             `bson:"aggregate_id"`
+	EventType     string             `bson:"event_type"`
+	Payload       bson.Raw           `bson:"payload"`
+	Headers       map[string]string  `bson:"headers,omitempty"`
+	Status        string             `bson:"status"`
+	Attempts      int                `bson:"attempts"`
+	LastError     string             `bson:"last_error,omitempty"`
+	NextAttemptAt time.Time          `bson:"next_attempt_at"`
+	LockedBy      string             `bson:"locked_by,omitempty"`
+	LockedUntil   time.Time          `bson:"locked_until"`
+	CreatedAt     time.Time          `bson:"created_at"`
+	DeliveredAt   *time.Time         `bson:"delivered_at"`
+}
+
+// NewOutboxEvent payload 需可编码为 bson 文档(结构体或 map)
+func NewOutboxEvent(aggregateType string, aggregateId string, eventType string, payload interface{}) (*OutboxEvent, error) {
+	data, err := bson.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal outbox payload: %w", err)
+	}
+	now := time.Now()
+	return &OutboxEvent{
+		Id:            primitive.NewObjectID(),
+		AggregateType: aggregateType,
+		AggregateId:   aggregateId,
+		EventType:     eventType,
+		Payload:       data,
+		Status:        OutboxPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}, nil
+}
+
+func (e *OutboxEvent) TableName() string {
+	return outboxCollection
+}
+
+func (e *OutboxEvent) PrimaryKey() interface{} {
+	return e.Id
+}
+
+func (e *OutboxEvent) PrimaryKeyName() string {
+	return "_id"
+}
+
+func (e *OutboxEvent) Indexes() []*IndexSpec {
+	return []*IndexSpec{
+		NewIndex("status", "aggregate_type", "aggregate_id", "_id"),
+		NewIndex("status", "delivered_at"),
+	}
+}
+
+// Decode 将 Payload 解码到 v
+func (e *OutboxEvent) Decode(v interface{}) error {
+	return bson.Unmarshal(e.Payload, v)
+}
+
+// AppendOutbox 写入发件箱事件,使用 WithContext(txCtx) 绑定事务时与业务写入一同提交
+func (i *MongodbDatabase) AppendOutbox(events ...*OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(events))
+	for index, event := range events {
+		docs[index] = event
+	}
+	_, err := i.insertMany(i.client.GetCtx(), outboxCollection, docs)
+	return wrapError(err)
+}
+
+// AppendOutbox 在默认数据源写入发件箱事件,ctx 为 txCtx 时加入该事务
+func AppendOutbox(ctx context.Context, events ...*OutboxEvent) error {
+	database, err := defaultDatabase[*OutboxEvent](ctx)
+	if err != nil {
+		return err
+	}
+	return database.AppendOutbox(events...)
+}
+
+func (i *MongodbGeneric[T]) AppendOutbox(events ...*OutboxEvent) error {
+	return i.database.AppendOutbox(events...)
+}
+
+// WriteWithEvents 在一个事务中执行 write 并写入事件,write 收到的仓库已绑定事务
+func (i *MongodbGeneric[T]) WriteWithEvents(write func(repo *MongodbGeneric[T]) error, events ...*OutboxEvent) error {
+	return i.WithTransaction(func(txCtx context.Context) error {
+		repo := i.WithContext(txCtx)
+		if err := write(repo); err != nil {
+			return err
+		}
+		return repo.AppendOutbox(events...)
+	})
+}
+
+// Publisher 事件投递目标,返回错误时事件按退避策略重试
+type Publisher interface {
+	Publish(ctx context.Context, event *OutboxEvent) error
+}
+
+type PublisherFunc func(ctx context.Context, event *OutboxEvent) error
+
+func (f PublisherFunc) Publish(ctx context.Context, event *OutboxEvent) error {
+	return f(ctx, event)
+}
+
+type RelayOptions struct {
+	batchSize    int
+	pollInterval time.Duration
+	maxAttempts  int
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	lease        time.Duration
+	retention    time.Duration
+	owner        string
+}
+
+func NewRelayOptions() *RelayOptions {
+	return &RelayOptions{
+		batchSize:    100,
+		pollInterval: time.Second,
+		maxAttempts:  10,
+		minBackoff:   time.Second,
+		maxBackoff:   5 * time.Minute,
+		lease:        30 * time.Second,
+		retention:    24 * time.Hour,
+	}
+}
+
+// BatchSize 每轮最多投递的聚合数量,每个聚合每轮投递最早的一个待投递事件
+func (op *RelayOptions) BatchSize(size int) *RelayOptions {
+	op.batchSize = size
+	return op
+}
+
+// PollInterval 没有待投递事件时的轮询间隔
+func (op *RelayOptions) PollInterval(interval time.Duration) *RelayOptions {
+	op.pollInterval = interval
+	return op
+}
+
+// MaxAttempts 最大投递次数,超过后事件标记为 OutboxDead
+func (op *RelayOptions) MaxAttempts(attempts int) *RelayOptions {
+	op.maxAttempts = attempts
+	return op
+}
+
+// Backoff 失败后的指数退避,从 min 开始每次翻倍,最长 max
+func (op *RelayOptions) Backoff(min time.Duration, max time.Duration) *RelayOptions {
+	op.minBackoff = min
+	op.maxBackoff = max
+	return op
+}
+
+// Lease 认领事件的租期,投递超过租期未完成的事件可被其他 relay 重新认领
+func (op *RelayOptions) Lease(lease time.Duration) *RelayOptions {
+	op.lease = lease
+	return op
+}
+
+// Retention 已投递事件的保留时间,为 0 时投递成功后立即删除
+func (op *RelayOptions) Retention(retention time.Duration) *RelayOptions {
+	op.retention = retention
+	return op
+}
+
+func (op *RelayOptions) Owner(owner string) *RelayOptions {
+	op.owner = owner
+	return op
+}
+
+/*
+*
+OutboxRelay 将发件箱中的事件投递到 Publisher,至少投递一次,Publisher 需保证幂等
+同一聚合(AggregateType + AggregateId)的事件按写入顺序投递,前一个事件未投递成功时后续事件等待
+多个 relay 实例可同时运行,通过租约认领事件
+*/
+type OutboxRelay struct {
+	database  *MongodbDatabase
+	publisher Publisher
+	ops       *RelayOptions
+}
+
+func NewOutboxRelay(database *MongodbDatabase, publisher Publisher, ops ...*RelayOptions) *OutboxRelay {
+	op := NewRelayOptions()
+	if len(ops) > 0 && ops[0] != nil {
+		op = ops[0]
+	}
+	if op.owner == "" {
+		host, _ := os.Hostname()
+		op.owner = fmt.Sprintf("%s-%d-%s", host, os.Getpid(), primitive.NewObjectID().Hex())
+	}
+	return &OutboxRelay{database: database, publisher: publisher, ops: op}
+}
+
+// Run 持续投递直到 ctx 取消,启动时创建 outbox 集合的索引
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	if _, err := r.database.EnsureIndexes(outboxCollection, (&OutboxEvent{}).Indexes()); err != nil {
+		return err
+	}
+	lastCleanup := time.Time{}
+	for {
+		// 数据库错误在下一轮重试
+		delivered, _ := r.RelayOnce(ctx)
+		if time.Since(lastCleanup) >= outboxCleanupInterval {
+			_, _ = r.Cleanup(ctx)
+			lastCleanup = time.Now()
+		}
+		if delivered > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.ops.pollInterval):
+		}
+	}
+}
+
+/*
+*
+RelayOnce 认领并投递每个聚合最早的待投递事件,返回投递成功的数量
+按 (aggregate_type, aggregate_id, _id) 排序以使用 (status, aggregate_type, aggregate_id, _id) 索引,
+积压超过内存排序上限时允许使用磁盘;聚合最早的事件被锁定或处于退避中时跳过该聚合
+*/
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	now := time.Now()
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"status": OutboxPending}}},
+		{{Key: "$sort", Value: bson.D{{Key: "aggregate_type", Value: 1}, {Key: "aggregate_id", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":  bson.M{"type": "$aggregate_type", "id": "$aggregate_id"},
+			"head": bson.M{"$first": "$$ROOT"},
+		}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$head"}}},
+		{{Key: "$match", Value: bson.M{"next_attempt_at": bson.M{"$lte": now}, "locked_until": bson.M{"$lte": now}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: r.ops.batchSize}},
+	}
+	cursor, err := r.database.aggregate(ctx, outboxCollection, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, wrapError(err)
+	}
+	var heads []*OutboxEvent
+	if err := cursor.All(ctx, &heads); err != nil {
+		return 0, wrapError(err)
+	}
+
+	// 单个事件的数据库错误不影响其他聚合,返回第一个错误
+	delivered := 0
+	var firstErr error
+	for _, event := range heads {
+		claimed, err := r.claim(ctx, event)
+		if err == nil && claimed {
+			err = r.deliver(ctx, event)
+			if err == nil {
+				delivered++
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return delivered, firstErr
+}
+
+func (r *OutboxRelay) claim(ctx context.Context, event *OutboxEvent) (bool, error) {
+	now := time.Now()
+	result, err := r.database.updateOne(ctx, outboxCollection,
+		bson.M{"_id": event.Id, "status": OutboxPending, "locked_until": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"locked_by": r.ops.owner, "locked_until": now.Add(r.ops.lease)}})
+	if err != nil {
+		return false, wrapError(err)
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// deliver 投递事件并记录结果,Publisher 返回的错误记录在事件中,只返回数据库错误
+func (r *OutboxRelay) deliver(ctx context.Context, event *OutboxEvent) error {
+	filter := bson.M{"_id": event.Id, "locked_by": r.ops.owner}
+	publishErr := r.publisher.Publish(ctx, event)
+	now := time.Now()
+	if publishErr == nil {
+		var err error
+		if r.ops.retention <= 0 {
+			_, err = r.database.deleteOne(ctx, outboxCollection, filter)
+		} else {
+			_, err = r.database.updateOne(ctx, outboxCollection, filter, bson.M{
+				"$set":   bson.M{"status": OutboxDelivered, "delivered_at": now},
+				"$inc":   bson.M{"attempts": 1},
+				"$unset": bson.M{"locked_by": "", "last_error": ""},
+			})
+		}
+		return wrapError(err)
+	}
+
+	attempts := event.Attempts + 1
+	set := bson.M{
+		"attempts":        attempts,
+		"last_error":      publishErr.Error(),
+		"next_attempt_at": now.Add(r.backoff(attempts)),
+		"locked_until":    now,
+	}
+	if attempts >= r.ops.maxAttempts {
+		set["status"] = OutboxDead
+	}
+	_, err := r.database.updateOne(ctx, outboxCollection, filter, bson.M{"$set": set, "$unset": bson.M{"locked_by": ""}})
+	return wrapError(err)
+}
+
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	backoff := r.ops.minBackoff
+	for n := 1; n < attempts && backoff < r.ops.maxBackoff; n++ {
+		backoff *= 2
+	}
+	if backoff > r.ops.maxBackoff {
+		backoff = r.ops.maxBackoff
+	}
+	return backoff
+}
+
+// Cleanup 删除超过保留时间的已投递事件
+func (r *OutboxRelay) Cleanup(ctx context.Context) (int64, error) {
+	result, err := r.database.deleteMany(ctx, outboxCollection, bson.M{
+		"status":       OutboxDelivered,
+		"delivered_at": bson.M{"$lt": time.Now().Add(-r.ops.retention)},
+	})
+	if err != nil {
+		return 0, wrapError(err)
+	}
+	return result.DeletedCount, nil
+}
+
+// Retry 将 OutboxDead 事件重新置为待投递
+func (r *OutboxRelay) Retry(ctx context.Context, ids ...primitive.ObjectID) error {
+	_, err := r.database.updateMany(ctx, outboxCollection,
+		bson.M{"_id": bson.M{"$in": ids}, "status": OutboxDead},
+		bson.M{"$set": bson.M{"status": OutboxPending, "attempts": 0, "next_attempt_at": time.Now()}})
+	return wrapError(err)
+}
@@ -0,0 +1,98 @@
+package mongokits
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// 大量积压中混合锁定、退避与可投递的聚合,每轮只投递可投递聚合最早的事件
+func TestRelayOnceLargeMixedBacklog(t *testing.T) {
+	database := openTestDatabase(t, outboxCollection)
+	ctx := context.Background()
+	if _, err := EnsureIndexes[*OutboxEvent](); err != nil {
+		t.Fatal(err)
+	}
+
+	const aggregates = 600
+	const perAggregate = 20
+	var events []*OutboxEvent
+	for seq := 0; seq < perAggregate; seq++ {
+		for agg := 0; agg < aggregates; agg++ {
+			event, err := NewOutboxEvent("order", fmt.Sprintf("order-%04d", agg), "changed", bson.M{"seq": seq})
+			if err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, event)
+		}
+	}
+	for start := 0; start < len(events); start += 1000 {
+		end := start + 1000
+		if end > len(events) {
+			end = len(events)
+		}
+		if err := database.AppendOutbox(events[start:end]...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 每 3 个聚合中一个最早事件被锁定,一个处于退避中
+	future := time.Now().Add(time.Hour)
+	for agg := 0; agg < aggregates; agg++ {
+		head := events[agg]
+		var set bson.M
+		switch agg % 3 {
+		case 1:
+			set = bson.M{"locked_until": future, "locked_by": "other"}
+		case 2:
+			set = bson.M{"next_attempt_at": future}
+		default:
+			continue
+		}
+		if _, err := database.GetRaw().Collection(outboxCollection).UpdateOne(ctx, bson.M{"_id": head.Id}, bson.M{"$set": set}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mutex sync.Mutex
+	published := make(map[string][]int)
+	publisher := PublisherFunc(func(ctx context.Context, event *OutboxEvent) error {
+		var payload struct {
+			Seq int `bson:"seq"`
+		}
+		if err := event.Decode(&payload); err != nil {
+			return err
+		}
+		mutex.Lock()
+		published[event.AggregateId] = append(published[event.AggregateId], payload.Seq)
+		mutex.Unlock()
+		return nil
+	})
+	relay := NewOutboxRelay(database, publisher, NewRelayOptions().BatchSize(aggregates))
+
+	for round := 0; round < 3; round++ {
+		delivered, err := relay.RelayOnce(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := aggregates / 3; delivered != want {
+			t.Fatalf("round %d delivered %d, want %d", round, delivered, want)
+		}
+	}
+	for agg := 0; agg < aggregates; agg++ {
+		seqs := published[fmt.Sprintf("order-%04d", agg)]
+		if agg%3 != 0 {
+			if len(seqs) != 0 {
+				t.Fatalf("aggregate %d with a blocked head delivered %v", agg, seqs)
+			}
+			continue
+		}
+		if len(seqs) != 3 || seqs[0] != 0 || seqs[1] != 1 || seqs[2] != 2 {
+			t.Fatalf("aggregate %d delivered out of order %v", agg, seqs)
+		}
+	}
+}
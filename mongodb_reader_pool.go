@@ -0,0 +1,316 @@
+package mongokits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultReaderMaxFailures   = 3
+	defaultReaderEjectDuration = 30 * time.Second
+	// 延迟的指数移动平均系数,新样本占 1/4
+	readerLatencyDecay = 4
+	// 按最低延迟选择时随机探测其他读库的比例
+	defaultReaderExplore = 0.05
+)
+
+type ReaderBalance int
+
+const (
+	// BalanceRoundRobin 按权重平滑轮询
+	BalanceRoundRobin ReaderBalance = iota
+	// BalanceLeastLatency 选择平均延迟/权重最小的读库,未测量过的读库优先;按 Explore 的比例随机选择,使未被选中的读库的延迟得到更新
+	BalanceLeastLatency
+)
+
+type ReaderPoolOptions struct {
+	balance        ReaderBalance
+	maxFailures    int
+	ejectDuration  time.Duration
+	healthInterval time.Duration
+	explore        float64
+}
+
+func NewReaderPoolOptions() *ReaderPoolOptions {
+	return &ReaderPoolOptions{
+		balance:       BalanceRoundRobin,
+		maxFailures:   defaultReaderMaxFailures,
+		ejectDuration: defaultReaderEjectDuration,
+		explore:       defaultReaderExplore,
+	}
+}
+
+func (op *ReaderPoolOptions) Balance(balance ReaderBalance) *ReaderPoolOptions {
+	op.balance = balance
+	return op
+}
+
+// MaxFailures 连续出现网络错误或超时的次数达到该值时摘除读库
+func (op *ReaderPoolOptions) MaxFailures(count int) *ReaderPoolOptions {
+	if count > 0 {
+		op.maxFailures = count
+	}
+	return op
+}
+
+// EjectDuration 读库被摘除的时长,到期后重新参与选择;开启健康检查时检查成功即恢复
+func (op *ReaderPoolOptions) EjectDuration(d time.Duration) *ReaderPoolOptions {
+	op.ejectDuration = d
+	return op
+}
+
+// Explore BalanceLeastLatency 时随机选择读库的比例,取值 0 到 1,默认 0.05,0 表示始终选择延迟最低的读库
+func (op *ReaderPoolOptions) Explore(ratio float64) *ReaderPoolOptions {
+	if ratio >= 0 && ratio <= 1 {
+		op.explore = ratio
+	}
+	return op
+}
+
+// HealthCheckInterval 定时 ping 所有读库,失败时摘除,成功时恢复并记录延迟,0 表示不检查
+func (op *ReaderPoolOptions) HealthCheckInterval(interval time.Duration) *ReaderPoolOptions {
+	op.healthInterval = interval
+	return op
+}
+
+type readerNode struct {
+	id       string
+	database *MongodbDatabase
+	weight   int
+	// current 平滑加权轮询的当前权重,由 pool.mutex 保护
+	current int
+	// latency 延迟的指数移动平均(纳秒)
+	latency      int64
+	failures     int32
+	ejectedUntil int64
+}
+
+func (n *readerNode) healthy(now time.Time) bool {
+	return atomic.LoadInt64(&n.ejectedUntil) <= now.UnixNano()
+}
+
+func (n *readerNode) observe(latency time.Duration) {
+	old := atomic.LoadInt64(&n.latency)
+	if old == 0 {
+		atomic.StoreInt64(&n.latency, int64(latency))
+		return
+	}
+	atomic.StoreInt64(&n.latency, old+(int64(latency)-old)/readerLatencyDecay)
+}
+
+// ReaderStatus 读库的当前状态
+type ReaderStatus struct {
+	Id       string
+	Weight   int
+	Healthy  bool
+	Latency  time.Duration
+	Failures int
+}
+
+/*
+*
+ReaderPool 读库池,按权重在多个读库间分配查询,连续失败或健康检查失败的读库被摘除
+没有可用读库时查询回退到写库,同一个 ReaderPool 可被多个读写分离仓库共享
+*/
+type ReaderPool struct {
+	mutex   sync.Mutex
+	readers []*readerNode
+	ops     *ReaderPoolOptions
+	stop    chan struct{}
+	once    sync.Once
+}
+
+func NewReaderPool(ops ...*ReaderPoolOptions) *ReaderPool {
+	op := NewReaderPoolOptions()
+	if len(ops) > 0 && ops[0] != nil {
+		op = ops[0]
+	}
+	pool := &ReaderPool{ops: op, stop: make(chan struct{})}
+	if op.healthInterval > 0 {
+		go pool.healthCheck()
+	}
+	return pool
+}
+
+// AddReader 添加数据源为读库,weight 小于等于 0 时按 1 处理
+func (p *ReaderPool) AddReader(datasourceId string, weight int) error {
+	database, err := GetDefaultManager().GetDatabaseById(datasourceId)
+	if err != nil {
+		return err
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, node := range p.readers {
+		if node.id == datasourceId {
+			return fmt.Errorf("reader %s already exists", datasourceId)
+		}
+	}
+	p.readers = append(p.readers, &readerNode{id: datasourceId, database: database, weight: weight})
+	return nil
+}
+
+// Close 停止健康检查
+func (p *ReaderPool) Close() {
+	p.once.Do(func() {
+		close(p.stop)
+	})
+}
+
+func (p *ReaderPool) Status() []ReaderStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := time.Now()
+	result := make([]ReaderStatus, 0, len(p.readers))
+	for _, node := range p.readers {
+		result = append(result, ReaderStatus{
+			Id:       node.id,
+			Weight:   node.weight,
+			Healthy:  node.healthy(now),
+			Latency:  time.Duration(atomic.LoadInt64(&node.latency)),
+			Failures: int(atomic.LoadInt32(&node.failures)),
+		})
+	}
+	return result
+}
+
+// pick 选择一个可用的读库,全部不可用时返回 nil
+func (p *ReaderPool) pick() *readerNode {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := time.Now()
+	var selected *readerNode
+	switch p.ops.balance {
+	case BalanceLeastLatency:
+		var best float64
+		var healthy []*readerNode
+		for _, node := range p.readers {
+			if !node.healthy(now) {
+				continue
+			}
+			healthy = append(healthy, node)
+			score := float64(atomic.LoadInt64(&node.latency)) / float64(node.weight)
+			if selected == nil || score < best {
+				selected, best = node, score
+			}
+		}
+		if len(healthy) > 1 && rand.Float64() < p.ops.explore {
+			selected = healthy[rand.Intn(len(healthy))]
+		}
+	default:
+		total := 0
+		for _, node := range p.readers {
+			if !node.healthy(now) {
+				continue
+			}
+			node.current += node.weight
+			total += node.weight
+			if selected == nil || node.current > selected.current {
+				selected = node
+			}
+		}
+		if selected != nil {
+			selected.current -= total
+		}
+	}
+	return selected
+}
+
+// report 记录一次查询的结果,只有网络错误与超时计入失败
+func (p *ReaderPool) report(node *readerNode, latency time.Duration, err error) {
+	if err != nil && (IsNetworkError(err) || IsTimeout(err)) {
+		if int(atomic.AddInt32(&node.failures, 1)) >= p.ops.maxFailures {
+			p.eject(node)
+		}
+		return
+	}
+	atomic.StoreInt32(&node.failures, 0)
+	node.observe(latency)
+}
+
+// eject 摘除读库并清空延迟,恢复后作为未测量的读库重新采样,不沿用摘除前的延迟
+func (p *ReaderPool) eject(node *readerNode) {
+	atomic.StoreInt64(&node.ejectedUntil, time.Now().Add(p.ops.ejectDuration).UnixNano())
+	atomic.StoreInt32(&node.failures, 0)
+	atomic.StoreInt64(&node.latency, 0)
+}
+
+func (p *ReaderPool) healthCheck() {
+	ticker := time.NewTicker(p.ops.healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		p.mutex.Lock()
+		readers := append([]*readerNode(nil), p.readers...)
+		p.mutex.Unlock()
+		for _, node := range readers {
+			p.ping(node)
+		}
+	}
+}
+
+func (p *ReaderPool) ping(node *readerNode) {
+	timeout := node.database.client.GetDuration()
+	if timeout <= 0 {
+		timeout = p.ops.healthInterval
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	if err := node.database.GetRaw().Client().Ping(ctx, nil); err != nil {
+		p.eject(node)
+		return
+	}
+	atomic.StoreInt64(&node.ejectedUntil, 0)
+	atomic.StoreInt32(&node.failures, 0)
+	node.observe(time.Since(start))
+}
+
+// GetGenericComplexDatabaseWithReaders 写库与读库池组成的读写分离仓库
+func GetGenericComplexDatabaseWithReaders[T Table](writerId string, readers *ReaderPool) (*MongodbGenericComplex[T], error) {
+	if readers == nil {
+		return nil, errors.New("reader pool required")
+	}
+	writer, err := GetDefaultManager().GetDatabaseById(writerId)
+	if nil != err {
+		return nil, err
+	}
+	return &MongodbGenericComplex[T]{
+		writer:  writer,
+		readers: readers,
+	}, nil
+}
+
+// read 在选中的数据源上执行查询,读库出现网络错误或超时时记录失败并在写库上重试一次
+func read[T Table, R any](i *MongodbGenericComplex[T], fn func(database *MongodbDatabase) (R, error)) (R, error) {
+	node := i.readNode()
+	if node == nil {
+		return fn(i.writer)
+	}
+	database := node.database
+	if i.ctx != nil {
+		database = database.WithContext(i.ctx)
+	}
+	start := time.Now()
+	result, err := fn(database)
+	if i.ctx != nil && i.ctx.Err() != nil {
+		// 调用方取消或超时,不计入读库失败
+		return result, err
+	}
+	i.readers.report(node, time.Since(start), err)
+	if err != nil && (IsNetworkError(err) || IsTimeout(err)) {
+		return fn(i.writer)
+	}
+	return result, err
+}
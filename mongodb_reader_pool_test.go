@@ -0,0 +1,56 @@
+package mongokits
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestReaderPool(op *ReaderPoolOptions, latencies ...time.Duration) *ReaderPool {
+	pool := NewReaderPool(op.Balance(BalanceLeastLatency))
+	for index, latency := range latencies {
+		node := &readerNode{id: string(rune('a' + index)), weight: 1}
+		node.observe(latency)
+		pool.readers = append(pool.readers, node)
+	}
+	return pool
+}
+
+func TestLeastLatencyExplores(t *testing.T) {
+	pool := newTestReaderPool(NewReaderPoolOptions().Explore(0), time.Millisecond, 100*time.Millisecond)
+	for n := 0; n < 100; n++ {
+		if pool.pick().id != "a" {
+			t.Fatal("without exploring the fastest reader should always be picked")
+		}
+	}
+
+	pool = newTestReaderPool(NewReaderPoolOptions().Explore(0.5), time.Millisecond, 100*time.Millisecond)
+	picked := make(map[string]int)
+	for n := 0; n < 1000; n++ {
+		picked[pool.pick().id]++
+	}
+	if picked["b"] == 0 || picked["a"] <= picked["b"] {
+		t.Fatalf("slower reader should be probed occasionally, got %v", picked)
+	}
+}
+
+// 摘除恢复后的读库不沿用摘除前的高延迟
+func TestLeastLatencyAfterEjection(t *testing.T) {
+	pool := newTestReaderPool(NewReaderPoolOptions().Explore(0).EjectDuration(time.Millisecond),
+		10*time.Millisecond, 500*time.Millisecond)
+	slow := pool.readers[1]
+	pool.eject(slow)
+	if pool.pick().id != "a" {
+		t.Fatal("ejected reader should not be picked")
+	}
+	time.Sleep(2 * time.Millisecond)
+	if node := pool.pick(); node != slow {
+		t.Fatalf("recovered reader should be sampled first, got %s", node.id)
+	}
+	pool.report(slow, 2*time.Millisecond, nil)
+	if got := pool.Status()[1].Latency; got != 2*time.Millisecond {
+		t.Fatalf("latency after recovery should come from new samples, got %v", got)
+	}
+	if pool.pick() != slow {
+		t.Fatal("recovered reader is now the fastest")
+	}
+}
@@ -0,0 +1,352 @@
+package mongokits
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type ValidationLevel string
+
+const (
+	ValidationLevelOff      ValidationLevel = "off"
+	ValidationLevelStrict   ValidationLevel = "strict"
+	ValidationLevelModerate ValidationLevel = "moderate"
+)
+
+type ValidationAction string
+
+const (
+	ValidationActionError ValidationAction = "error"
+	ValidationActionWarn  ValidationAction = "warn"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	objectIdType   = reflect.TypeOf(primitive.ObjectID{})
+	dateTimeType   = reflect.TypeOf(primitive.DateTime(0))
+	decimalType    = reflect.TypeOf(primitive.Decimal128{})
+	timestampType  = reflect.TypeOf(primitive.Timestamp{})
+	binaryType     = reflect.TypeOf(primitive.Binary{})
+	regexType      = reflect.TypeOf(primitive.Regex{})
+	bsonDocType    = reflect.TypeOf(bson.D{})
+	bsonRawType    = reflect.TypeOf(bson.Raw{})
+	byteSliceType  = reflect.TypeOf([]byte{})
+	emptyIfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+/*
+*
+根据 Table 结构体的字段与 bson 标签生成 $jsonSchema 校验器
+字段标签:
+
+	schema:"required"          必填字段
+	schema_enum:"a,b,c"        枚举值(按字段类型转换)
+	schema_pattern:"^[a-z]+$"  字符串正则
+*/
+func GenerateSchema[T Table]() (bson.M, error) {
+	var r T
+	t := reflect.TypeOf(r)
+	if t == nil {
+		return nil, fmt.Errorf("can not generate schema for %T", r)
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can not generate schema for %s, struct required", t.String())
+	}
+	schema, err := structSchema(t, map[reflect.Type]bool{})
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"$jsonSchema": schema}, nil
+}
+
+func structSchema(t reflect.Type, visiting map[reflect.Type]bool) (bson.M, error) {
+	if visiting[t] {
+		// 自引用结构只校验类型
+		return bson.M{"bsonType": "object"}, nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	properties := bson.M{}
+	var required []string
+	if err := collectProperties(t, visiting, properties, &required); err != nil {
+		return nil, err
+	}
+	schema := bson.M{"bsonType": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema, nil
+}
+
+func collectProperties(t reflect.Type, visiting map[reflect.Type]bool, properties bson.M, required *[]string) error {
+	for index := 0; index < t.NumField(); index++ {
+		field := t.Field(index)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		name, inline, skip := bsonFieldName(field)
+		if skip {
+			continue
+		}
+		if inline {
+			ft := field.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := collectProperties(ft, visiting, properties, required); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		prop, err := typeSchema(field.Type, visiting)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		if values, ok := field.Tag.Lookup("schema_enum"); ok {
+			enum, err := enumValues(field.Type, values)
+			if err != nil {
+				return fmt.Errorf("field %s: %w", field.Name, err)
+			}
+			if field.Type.Kind() == reflect.Ptr {
+				enum = append(enum, nil)
+			}
+			prop["enum"] = enum
+		}
+		if pattern, ok := field.Tag.Lookup("schema_pattern"); ok {
+			prop["pattern"] = pattern
+		}
+		properties[name] = prop
+		for _, opt := range strings.Split(field.Tag.Get("schema"), ",") {
+			if strings.TrimSpace(opt) == "required" {
+				*required = append(*required, name)
+			}
+		}
+	}
+	return nil
+}
+
+// bsonFieldName 与驱动默认的结构体编码规则保持一致:无标签时使用小写字段名
+func bsonFieldName(field reflect.StructField) (name string, inline bool, skip bool) {
+	tag, ok := field.Tag.Lookup("bson")
+	if !ok && !strings.Contains(string(field.Tag), ":") && len(field.Tag) > 0 {
+		tag = string(field.Tag)
+	}
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	for _, opt := range parts[1:] {
+		if opt == "inline" {
+			inline = true
+		}
+	}
+	name = parts[0]
+	if name == "" {
+		if field.Anonymous && field.PkgPath != "" && !inline {
+			return "", false, true
+		}
+		name = strings.ToLower(field.Name)
+	}
+	return name, inline, false
+}
+
+func typeSchema(t reflect.Type, visiting map[reflect.Type]bool) (bson.M, error) {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		nullable = true
+		t = t.Elem()
+	}
+	schema, err := baseTypeSchema(t, visiting)
+	if err != nil {
+		return nil, err
+	}
+	if nullable {
+		if bsonType, ok := schema["bsonType"].(string); ok {
+			schema["bsonType"] = bson.A{bsonType, "null"}
+		} else if bsonTypes, ok := schema["bsonType"].(bson.A); ok && !containsValue(bsonTypes, "null") {
+			schema["bsonType"] = append(bsonTypes, "null")
+		}
+	}
+	return schema, nil
+}
+
+func baseTypeSchema(t reflect.Type, visiting map[reflect.Type]bool) (bson.M, error) {
+	switch t {
+	case timeType, dateTimeType:
+		return bson.M{"bsonType": "date"}, nil
+	case objectIdType:
+		return bson.M{"bsonType": "objectId"}, nil
+	case decimalType:
+		return bson.M{"bsonType": "decimal"}, nil
+	case timestampType:
+		return bson.M{"bsonType": "timestamp"}, nil
+	case binaryType:
+		return bson.M{"bsonType": "binData"}, nil
+	case byteSliceType:
+		// nil 的切片与 map 编码为 null
+		return bson.M{"bsonType": bson.A{"binData", "null"}}, nil
+	case regexType:
+		return bson.M{"bsonType": "regex"}, nil
+	case bsonDocType, bsonRawType:
+		return bson.M{"bsonType": bson.A{"object", "null"}}, nil
+	case emptyIfaceType:
+		return bson.M{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return bson.M{"bsonType": "string"}, nil
+	case reflect.Bool:
+		return bson.M{"bsonType": "bool"}, nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return bson.M{"bsonType": "int"}, nil
+	case reflect.Int64, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		return bson.M{"bsonType": bson.A{"int", "long"}}, nil
+	case reflect.Int:
+		// 驱动按数值大小将 int 编码为 int32 或 int64
+		return bson.M{"bsonType": bson.A{"int", "long"}}, nil
+	case reflect.Float32, reflect.Float64:
+		return bson.M{"bsonType": "double"}, nil
+	case reflect.Struct:
+		return structSchema(t, visiting)
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", t.Key().String())
+		}
+		elem, err := typeSchema(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		schema := bson.M{"bsonType": bson.A{"object", "null"}}
+		if len(elem) > 0 {
+			schema["additionalProperties"] = elem
+		}
+		return schema, nil
+	case reflect.Slice, reflect.Array:
+		items, err := typeSchema(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		schema := bson.M{"bsonType": bson.A{"array", "null"}}
+		if t.Kind() == reflect.Array {
+			schema["bsonType"] = "array"
+		}
+		if len(items) > 0 {
+			schema["items"] = items
+		}
+		return schema, nil
+	case reflect.Interface:
+		return bson.M{}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t.String())
+}
+
+func enumValues(t reflect.Type, values string) (bson.A, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var enum bson.A
+	for _, value := range strings.Split(values, ",") {
+		value = strings.TrimSpace(value)
+		switch t.Kind() {
+		case reflect.String:
+			enum = append(enum, value)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			var n int64
+			if _, err := fmt.Sscan(value, &n); err != nil {
+				return nil, fmt.Errorf("invalid enum value %s: %w", value, err)
+			}
+			enum = append(enum, n)
+		case reflect.Float32, reflect.Float64:
+			var f float64
+			if _, err := fmt.Sscan(value, &f); err != nil {
+				return nil, fmt.Errorf("invalid enum value %s: %w", value, err)
+			}
+			enum = append(enum, f)
+		default:
+			return nil, fmt.Errorf("enum not supported for type %s", t.String())
+		}
+	}
+	return enum, nil
+}
+
+// ApplyValidator 为集合设置校验器,集合存在时使用 collMod,不存在时创建集合
+func (i *MongodbDatabase) ApplyValidator(tableName string, validator bson.M, level ValidationLevel, action ValidationAction) error {
+	ctx := i.client.GetCtx()
+	names, err := i.listCollectionNames(ctx, bson.M{"name": tableName})
+	if err != nil {
+		return wrapError(err)
+	}
+	command := "create"
+	if len(names) > 0 {
+		command = "collMod"
+	}
+	cmd := bson.D{
+		{Key: command, Value: tableName},
+		{Key: "validator", Value: validator},
+		{Key: "validationLevel", Value: string(level)},
+		{Key: "validationAction", Value: string(action)},
+	}
+	return wrapError(i.runCommand(ctx, cmd))
+}
+
+// ApplySchema 生成 T 的 $jsonSchema 并应用到 T 对应的集合
+func ApplySchema[T Table](database *MongodbDatabase, level ValidationLevel, action ValidationAction) error {
+	validator, err := GenerateSchema[T]()
+	if err != nil {
+		return err
+	}
+	var r T
+	return database.ApplyValidator(r.TableName(), validator, level, action)
+}
+
+func (i *MongodbGeneric[T]) ApplySchema(level ValidationLevel, action ValidationAction) error {
+	return ApplySchema[T](i.database, level, action)
+}
+
+func (i *MongodbGenericComplex[T]) ApplySchema(level ValidationLevel, action ValidationAction) error {
+	return ApplySchema[T](i.writer, level, action)
+}
+
+// GetValidator 读取集合当前的校验器,未设置时返回 nil
+func (i *MongodbDatabase) GetValidator(tableName string) (bson.M, error) {
+	ctx := i.client.GetCtx()
+	cursor, err := i.listCollections(ctx, bson.M{"name": tableName})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	defer cursor.Close(ctx)
+	var specs []struct {
+		Options struct {
+			Validator bson.M `bson:"validator"`
+		} `bson:"options"`
+	}
+	if err := cursor.All(ctx, &specs); err != nil {
+		return nil, wrapError(err)
+	}
+	if len(specs) == 0 {
+		return nil, ErrorDocumentNotFound
+	}
+	return specs[0].Options.Validator, nil
+}
+
+func containsValue(values bson.A, value interface{}) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
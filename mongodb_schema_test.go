@@ -0,0 +1,121 @@
+package mongokits
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type schemaBase struct {
+	CreatedAt time.Time `bson:"created_at" schema:"required"`
+}
+
+type schemaAddress struct {
+	City string `bson:"city" schema:"required"`
+}
+
+type schemaItem struct {
+	schemaBase `bson:",inline"`
+	Id         primitive.ObjectID     `bson:"_id" schema:"required"`
+	Name       string                 `bson:"name" schema_pattern:"^[a-z]+$"`
+	Status     string                 `bson:"status" schema_enum:"on,off"`
+	Level      *int32                 `bson:"level" schema_enum:"1,2"`
+	Count      int                    `bson:"count"`
+	Small      int16                  `bson:"small"`
+	Total      int64                  `bson:"total"`
+	Ratio      float64                `bson:"ratio"`
+	Address    *schemaAddress         `bson:"address"`
+	Labels     map[string]string      `bson:"labels"`
+	Tags       []string               `bson:"tags"`
+	Data       []byte                 `bson:"data"`
+	Raw        bson.Raw               `bson:"raw"`
+	Extra      map[string]interface{} `bson:"extra"`
+	Ignored    string                 `bson:"-"`
+	Plain      bool
+}
+
+func (s *schemaItem) TableName() string       { return "test_schema_items" }
+func (s *schemaItem) PrimaryKey() interface{} { return s.Id }
+func (s *schemaItem) PrimaryKeyName() string  { return "_id" }
+
+func TestGenerateSchema(t *testing.T) {
+	validator, err := GenerateSchema[*schemaItem]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := validator["$jsonSchema"].(bson.M)
+	if !reflect.DeepEqual(schema["required"], []string{"created_at", "_id"}) {
+		t.Errorf("required %v", schema["required"])
+	}
+	properties := schema["properties"].(bson.M)
+	for name, want := range map[string]bson.M{
+		"created_at": {"bsonType": "date"},
+		"_id":        {"bsonType": "objectId"},
+		"name":       {"bsonType": "string", "pattern": "^[a-z]+$"},
+		"status":     {"bsonType": "string", "enum": bson.A{"on", "off"}},
+		"level":      {"bsonType": bson.A{"int", "null"}, "enum": bson.A{int64(1), int64(2), nil}},
+		"count":      {"bsonType": bson.A{"int", "long"}},
+		"small":      {"bsonType": "int"},
+		"total":      {"bsonType": bson.A{"int", "long"}},
+		"ratio":      {"bsonType": "double"},
+		"address": {
+			"bsonType":   bson.A{"object", "null"},
+			"properties": bson.M{"city": bson.M{"bsonType": "string"}},
+			"required":   []string{"city"},
+		},
+		"labels": {"bsonType": bson.A{"object", "null"}, "additionalProperties": bson.M{"bsonType": "string"}},
+		"tags":   {"bsonType": bson.A{"array", "null"}, "items": bson.M{"bsonType": "string"}},
+		"data":   {"bsonType": bson.A{"binData", "null"}},
+		"raw":    {"bsonType": bson.A{"object", "null"}},
+		"extra":  {"bsonType": bson.A{"object", "null"}},
+		"plain":  {"bsonType": "bool"},
+	} {
+		if !reflect.DeepEqual(properties[name], want) {
+			t.Errorf("%s: %v, want %v", name, properties[name], want)
+		}
+	}
+	if len(properties) != 16 {
+		t.Errorf("unexpected properties %v", properties)
+	}
+}
+
+// 零值文档编码后 nil 的 map、切片与指针为 null,生成的校验器需允许
+func TestGenerateSchemaAllowsNil(t *testing.T) {
+	empty, _ := bson.Marshal(bson.D{})
+	encoded, err := bson.Marshal(&schemaItem{Raw: empty})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator, _ := GenerateSchema[*schemaItem]()
+	properties := validator["$jsonSchema"].(bson.M)["properties"].(bson.M)
+	for _, name := range []string{"labels", "tags", "data", "extra", "address", "level"} {
+		if value := bson.Raw(encoded).Lookup(name); value.Type != bsontype.Null {
+			t.Fatalf("%s encodes to %v", name, value.Type)
+		}
+		types, _ := properties[name].(bson.M)["bsonType"].(bson.A)
+		if !containsValue(types, "null") {
+			t.Errorf("%s encodes to null but schema allows %v", name, properties[name])
+		}
+	}
+}
+
+func TestGenerateSchemaErrors(t *testing.T) {
+	if _, err := GenerateSchema[Table](); err == nil {
+		t.Error("interface table should fail")
+	}
+	if _, err := GenerateSchema[*badEnumItem](); err == nil {
+		t.Error("invalid enum should fail")
+	}
+}
+
+type badEnumItem struct {
+	Count int `bson:"count" schema_enum:"a"`
+}
+
+func (b *badEnumItem) TableName() string       { return "test_bad_enum_items" }
+func (b *badEnumItem) PrimaryKey() interface{} { return nil }
+func (b *badEnumItem) PrimaryKeyName() string  { return "_id" }
@@ -0,0 +1,452 @@
+package mongokits
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const defaultHashReplicas = 160
+
+/*
+*
+Sharded Table 实现该接口后可使用分片仓库,ShardKeyField 返回分片键字段名(bson 名称),ShardKey 返回文档的分片键
+分片键写入后不可修改,修改后文档会被路由到其他数据源
+*/
+type Sharded interface {
+	ShardKeyField() string
+	ShardKey() interface{}
+}
+
+// ShardRouter 根据分片键选择数据源 id,Shards 返回全部数据源 id
+type ShardRouter interface {
+	Route(key interface{}) (string, error)
+	Shards() []string
+}
+
+// HashRouter 一致性哈希路由,每个数据源在环上有 replicas 个虚拟节点,增加数据源时只迁移少量数据
+type HashRouter struct {
+	ring   []uint32
+	nodes  map[uint32]string
+	shards []string
+}
+
+// NewHashRouter replicas 小于等于 0 时使用 160
+func NewHashRouter(replicas int, datasourceIds ...string) *HashRouter {
+	if replicas <= 0 {
+		replicas = defaultHashReplicas
+	}
+	router := &HashRouter{nodes: make(map[uint32]string), shards: datasourceIds}
+	for _, id := range datasourceIds {
+		for replica := 0; replica < replicas; replica++ {
+			hash := crc32.ChecksumIEEE([]byte(id + "#" + strconv.Itoa(replica)))
+			if _, exists := router.nodes[hash]; exists {
+				continue
+			}
+			router.nodes[hash] = id
+			router.ring = append(router.ring, hash)
+		}
+	}
+	sort.Slice(router.ring, func(x, y int) bool {
+		return router.ring[x] < router.ring[y]
+	})
+	return router
+}
+
+func (r *HashRouter) Route(key interface{}) (string, error) {
+	if len(r.ring) == 0 {
+		return "", fmt.Errorf("hash router has no shards")
+	}
+	hash := crc32.ChecksumIEEE([]byte(shardKeyString(key)))
+	index := sort.Search(len(r.ring), func(i int) bool {
+		return r.ring[i] >= hash
+	})
+	if index == len(r.ring) {
+		index = 0
+	}
+	return r.nodes[r.ring[index]], nil
+}
+
+func (r *HashRouter) Shards() []string {
+	return r.shards
+}
+
+func shardKeyString(key interface{}) string {
+	if oid, ok := key.(primitive.ObjectID); ok {
+		return oid.Hex()
+	}
+	return fmt.Sprint(key)
+}
+
+type shardRange struct {
+	lower        interface{}
+	datasourceId string
+}
+
+// RangeRouter 范围路由,分片键大于等于 lower 且小于下一个范围的 lower 时路由到该数据源
+type RangeRouter struct {
+	ranges []shardRange
+	shards []string
+}
+
+func NewRangeRouter() *RangeRouter {
+	return &RangeRouter{}
+}
+
+// Add 添加范围,lower 为 nil 表示最小值;支持数值、字符串、时间与 ObjectId,不支持的类型在启动时 panic
+func (r *RangeRouter) Add(lower interface{}, datasourceId string) *RangeRouter {
+	key, ok := normalizeKey(lower)
+	if !ok {
+		panic(fmt.Errorf("unsupported range lower bound type %T", lower))
+	}
+	r.ranges = append(r.ranges, shardRange{lower: key, datasourceId: datasourceId})
+	sort.SliceStable(r.ranges, func(x, y int) bool {
+		return compareKeys(r.ranges[x].lower, r.ranges[y].lower) < 0
+	})
+	for _, id := range r.shards {
+		if id == datasourceId {
+			return r
+		}
+	}
+	r.shards = append(r.shards, datasourceId)
+	return r
+}
+
+func (r *RangeRouter) Route(key interface{}) (string, error) {
+	value, ok := normalizeKey(key)
+	if !ok {
+		return "", fmt.Errorf("unsupported shard key type %T", key)
+	}
+	index := sort.Search(len(r.ranges), func(i int) bool {
+		return compareKeys(r.ranges[i].lower, value) > 0
+	})
+	if index == 0 {
+		return "", fmt.Errorf("shard key %v out of range", key)
+	}
+	return r.ranges[index-1].datasourceId, nil
+}
+
+func (r *RangeRouter) Shards() []string {
+	return r.shards
+}
+
+/*
+*
+MongodbSharded 分片仓库,将同一类型的文档按分片键分布到多个数据源
+条件中包含分片键(等值或 $in)时只访问对应的数据源,否则并发访问全部数据源并合并结果
+跨数据源的写入不在同一事务中
+*/
+type MongodbSharded[T Table] struct {
+	router    ShardRouter
+	databases map[string]*MongodbDatabase
+	ctx       context.Context
+}
+
+// GetShardedDatabase 数据源 id 需已在 MongodbCreator 中注册
+func GetShardedDatabase[T Table](router ShardRouter) (*MongodbSharded[T], error) {
+	var r T
+	if !hasHook[T, Sharded]() {
+		return nil, fmt.Errorf("table %s does not implement Sharded", r.TableName())
+	}
+	databases := make(map[string]*MongodbDatabase)
+	for _, id := range router.Shards() {
+		db, err := GetDefaultManager().GetDatabaseById(id)
+		if nil != err {
+			return nil, err
+		}
+		databases[id] = db
+	}
+	return &MongodbSharded[T]{
+		router:    router,
+		databases: databases,
+	}, nil
+}
+
+func (i *MongodbSharded[T]) WithContext(ctx context.Context) *MongodbSharded[T] {
+	g := *i
+	g.ctx = ctx
+	return &g
+}
+
+func (i *MongodbSharded[T]) getCtx() context.Context {
+	if i.ctx == nil {
+		return context.TODO()
+	}
+	return i.ctx
+}
+
+func (i *MongodbSharded[T]) database(id string) *MongodbDatabase {
+	db := i.databases[id]
+	if i.ctx != nil {
+		db = db.WithContext(i.ctx)
+	}
+	return db
+}
+
+func (i *MongodbSharded[T]) route(key interface{}) (string, error) {
+	id, err := i.router.Route(key)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := i.databases[id]; !ok {
+		return "", fmt.Errorf("database %s no exists", id)
+	}
+	return id, nil
+}
+
+// Shard 返回分片键所在数据源的普通仓库,用于分片仓库未提供的操作
+func (i *MongodbSharded[T]) Shard(key interface{}) (*MongodbGeneric[T], error) {
+	id, err := i.route(key)
+	if err != nil {
+		return nil, err
+	}
+	g := &MongodbGeneric[T]{database: i.databases[id]}
+	if i.ctx != nil {
+		g = g.WithContext(i.ctx)
+	}
+	return g, nil
+}
+
+func (i *MongodbSharded[T]) shardKeyField() string {
+	var r T
+	sharded, _ := lookupHook[Sharded](r, &r)
+	return sharded.ShardKeyField()
+}
+
+func (i *MongodbSharded[T]) docShard(doc T) (string, error) {
+	sharded, _ := lookupHook[Sharded](doc, &doc)
+	key := sharded.ShardKey()
+	if key == nil {
+		return "", ErrorShardKeyRequired
+	}
+	return i.route(key)
+}
+
+// condShards 条件中的分片键为标量等值、$eq 或 $in 时返回对应的数据源,否则返回全部数据源,exact 表示是否由分片键确定
+func (i *MongodbSharded[T]) condShards(cond interface{}) (ids []string, exact bool, err error) {
+	var filter map[string]interface{}
+	switch c := cond.(type) {
+	case bson.M:
+		filter = c
+	case map[string]interface{}:
+		filter = c
+	}
+	value, exists := filter[i.shardKeyField()]
+	if !exists {
+		return i.router.Shards(), false, nil
+	}
+	keys, ok := shardKeys(value)
+	if !ok {
+		return i.router.Shards(), false, nil
+	}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		id, err := i.route(key)
+		if err != nil {
+			return nil, false, err
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, true, nil
+}
+
+// shardKeys 分片键条件确定的键值,只识别标量等值与 $eq、$in 操作符(其余操作符只会缩小范围);
+// 正则、数组、嵌套文档等无法按等值路由的条件返回 false
+func shardKeys(value interface{}) ([]interface{}, bool) {
+	var operators bson.D
+	switch v := value.(type) {
+	case bson.D:
+		operators = v
+	case bson.M:
+		for key, operand := range v {
+			operators = append(operators, bson.E{Key: key, Value: operand})
+		}
+	case map[string]interface{}:
+		for key, operand := range v {
+			operators = append(operators, bson.E{Key: key, Value: operand})
+		}
+	default:
+		if !isScalarKey(value) {
+			return nil, false
+		}
+		return []interface{}{value}, true
+	}
+	for _, operator := range operators {
+		switch operator.Key {
+		case "$eq":
+			if isScalarKey(operator.Value) {
+				return []interface{}{operator.Value}, true
+			}
+		case "$in":
+			var keys []interface{}
+			switch in := operator.Value.(type) {
+			case bson.A:
+				keys = in
+			case []interface{}:
+				keys = in
+			}
+			for _, key := range keys {
+				if !isScalarKey(key) {
+					return nil, false
+				}
+			}
+			if len(keys) > 0 {
+				return keys, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// isScalarKey 可直接作为分片键路由的值,正则与文档、数组类的值匹配的不是单个键
+func isScalarKey(value interface{}) bool {
+	switch value.(type) {
+	case nil, primitive.Regex, bson.D, bson.M, bson.A, map[string]interface{}, []interface{}:
+		return false
+	}
+	return true
+}
+
+func (i *MongodbSharded[T]) Insert(doc T) (string, error) {
+	id, err := i.docShard(doc)
+	if err != nil {
+		return "", err
+	}
+	g := &MongodbGeneric[T]{database: i.database(id), ctx: i.ctx}
+	return g.Insert(doc)
+}
+
+// InsertMany 按分片键分组后分别写入各数据源,结果中的下标对应 docs 的原始下标
+func (i *MongodbSharded[T]) InsertMany(docs []T, ops ...*InsertManyOptions) (*InsertManyResult[string], error) {
+	return insertGroups(docs, i.docShard, func(id string, part []T) (*InsertManyResult[string], error) {
+		return insertMany[T, string](i.getCtx(), i.database(id), part, ops...)
+	})
+}
+
+func (i *MongodbSharded[T]) Update(doc T) error {
+	id, err := i.docShard(doc)
+	if err != nil {
+		return err
+	}
+	if err := prepareUpdate(i.getCtx(), doc, &doc); err != nil {
+		return err
+	}
+	return updateDocument(i.database(id), doc)
+}
+
+// UpdateSet 条件中必须包含分片键,否则返回 ErrorShardKeyRequired
+func (i *MongodbSharded[T]) UpdateSet(cond bson.M, setter bson.M) error {
+	ids, exact, err := i.condShards(cond)
+	if err != nil {
+		return err
+	}
+	if !exact || len(ids) != 1 {
+		return ErrorShardKeyRequired
+	}
+	return updateSet[T](i.getCtx(), i.database(ids[0]), cond, setter)
+}
+
+// Delete id 中不包含分片键,在全部数据源上删除
+func (i *MongodbSharded[T]) Delete(ids ...string) error {
+	return i.each(i.router.Shards(), func(database *MongodbDatabase) error {
+		return deleteByIds[T](i.getCtx(), database, ids, false)
+	})
+}
+
+// GetById id 中不包含分片键,并发查询全部数据源
+func (i *MongodbSharded[T]) GetById(id string) (T, error) {
+	var r T
+	oid, err := parseObjectId(id)
+	if err != nil {
+		return r, err
+	}
+	result, err := i.QueryByCond(bson.M{"_id": oid}, &options.FindOptions{})
+	if err != nil {
+		return r, err
+	}
+	if len(result) == 0 {
+		return r, ErrorDocumentNotFound
+	}
+	return result[0], nil
+}
+
+// QueryByCond 跨数据源查询时 op 的排序、跳过与数量作用于合并后的结果,排序需为 bson.D 或单个字段的 bson.M
+func (i *MongodbSharded[T]) QueryByCond(cond interface{}, op *options.FindOptions) ([]T, error) {
+	ids, _, err := i.condShards(cond)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 1 {
+		return queryAll[T](i.getCtx(), i.database(ids[0]), scopeExcludeDeleted, cond, op)
+	}
+	queries := make([]func(op *options.FindOptions) ([]T, error), len(ids))
+	for index, id := range ids {
+		database := i.database(id)
+		queries[index] = func(op *options.FindOptions) ([]T, error) {
+			return queryAll[T](i.getCtx(), database, scopeExcludeDeleted, cond, op)
+		}
+	}
+	return fanOutQuery(queries, op, true)
+}
+
+func (i *MongodbSharded[T]) GetByCond(cond bson.M, op *options.FindOptions) (T, error) {
+	var r T
+	o := options.FindOptions{}
+	if op != nil {
+		o = *op
+	}
+	result, err := i.QueryByCond(cond, o.SetLimit(1))
+	if err != nil {
+		return r, err
+	}
+	if len(result) == 0 {
+		return r, ErrorDocumentNotFound
+	}
+	return result[0], nil
+}
+
+func (i *MongodbSharded[T]) Count(filter bson.M) (int64, error) {
+	ids, _, err := i.condShards(filter)
+	if err != nil {
+		return 0, err
+	}
+	var mutex sync.Mutex
+	var total int64
+	err = i.each(ids, func(database *MongodbDatabase) error {
+		count, err := countDocuments[T](i.getCtx(), database, scopeExcludeDeleted, filter)
+		mutex.Lock()
+		total += count
+		mutex.Unlock()
+		return err
+	})
+	return total, err
+}
+
+// each 并发在多个数据源上执行 fn,返回第一个错误
+func (i *MongodbSharded[T]) each(ids []string, fn func(database *MongodbDatabase) error) error {
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for index, id := range ids {
+		wg.Add(1)
+		go func(index int, database *MongodbDatabase) {
+			defer wg.Done()
+			errs[index] = fn(database)
+		}(index, i.database(id))
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package mongokits
+
+import (
+	"math"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type shardedItem struct {
+	Id     primitive.ObjectID `bson:"_id"`
+	Tenant string             `bson:"tenant"`
+}
+
+func (s *shardedItem) TableName() string       { return "test_sharded_items" }
+func (s *shardedItem) PrimaryKey() interface{} { return s.Id }
+func (s *shardedItem) PrimaryKeyName() string  { return "_id" }
+func (s *shardedItem) ShardKeyField() string   { return "tenant" }
+func (s *shardedItem) ShardKey() interface{}   { return s.Tenant }
+
+func TestCondShards(t *testing.T) {
+	router := NewRangeRouter().Add(nil, "a").Add("m", "b")
+	sharded := &MongodbSharded[*shardedItem]{
+		router:    router,
+		databases: map[string]*MongodbDatabase{"a": nil, "b": nil},
+	}
+	cases := []struct {
+		name  string
+		cond  interface{}
+		ids   []string
+		exact bool
+	}{
+		{"scalar", bson.M{"tenant": "c"}, []string{"a"}, true},
+		{"eq", bson.M{"tenant": bson.M{"$eq": "x"}}, []string{"b"}, true},
+		{"in", bson.M{"tenant": bson.M{"$in": bson.A{"c", "x", "d"}}}, []string{"a", "b"}, true},
+		{"in bson.D", bson.M{"tenant": bson.D{{Key: "$in", Value: bson.A{"x"}}}}, []string{"b"}, true},
+		{"in map", map[string]interface{}{"tenant": map[string]interface{}{"$in": []interface{}{"c"}}}, []string{"a"}, true},
+		{"range", bson.M{"tenant": bson.M{"$gte": "x"}}, []string{"a", "b"}, false},
+		{"range bson.D", bson.M{"tenant": bson.D{{Key: "$gt", Value: "c"}}}, []string{"a", "b"}, false},
+		{"operator map", bson.M{"tenant": map[string]interface{}{"$ne": "c"}}, []string{"a", "b"}, false},
+		{"regex", bson.M{"tenant": primitive.Regex{Pattern: "^c"}}, []string{"a", "b"}, false},
+		{"regex in", bson.M{"tenant": bson.M{"$in": bson.A{primitive.Regex{Pattern: "^c"}}}}, []string{"a", "b"}, false},
+		{"missing", bson.M{"name": "c"}, []string{"a", "b"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ids, exact, err := sharded.condShards(c.cond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exact != c.exact || len(ids) != len(c.ids) {
+				t.Fatalf("got %v exact=%v, want %v exact=%v", ids, exact, c.ids, c.exact)
+			}
+			for index := range ids {
+				if ids[index] != c.ids[index] {
+					t.Fatalf("got %v, want %v", ids, c.ids)
+				}
+			}
+		})
+	}
+}
+
+func TestRangeRouterBounds(t *testing.T) {
+	router := NewRangeRouter().Add(uint64(math.MaxUint64), "c").Add(nil, "a").Add(uint(100), "b")
+	for _, c := range []struct {
+		key  interface{}
+		want string
+	}{
+		{int32(5), "a"},
+		{uint(100), "b"},
+		{uint64(1) << 62, "b"},
+		{uint64(math.MaxUint64), "c"},
+	} {
+		if got, err := router.Route(c.key); err != nil || got != c.want {
+			t.Errorf("Route(%v) = %q %v, want %q", c.key, got, err, c.want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("unsupported lower bound should panic")
+		}
+	}()
+	NewRangeRouter().Add(struct{}{}, "a")
+}
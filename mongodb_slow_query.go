@@ -0,0 +1,172 @@
+package mongokits
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultSlowQueryCapacity = 100
+
+// SlowQuery 一次超过阈值的操作,Filter 与 Pipeline 已隐藏敏感字段的值
+type SlowQuery struct {
+	Datasource string
+	Database   string
+	Collection string
+	Operation  string
+	Filter     interface{}
+	Sort       interface{}
+	Pipeline   interface{}
+	Duration   time.Duration
+	// Method 发起操作的仓库方法,如 MongodbGeneric.GetById
+	Method string
+	Time   time.Time
+	Err    error
+}
+
+// SlowQuerySink 接收慢操作,在操作所在的 goroutine 中同步调用
+type SlowQuerySink func(ctx context.Context, query SlowQuery)
+
+// slowLog 最近的慢操作,容量满后覆盖最早的记录
+type slowLog struct {
+	mutex   sync.Mutex
+	entries []SlowQuery
+	next    int
+	full    bool
+}
+
+func newSlowLog(capacity int) *slowLog {
+	if capacity <= 0 {
+		capacity = defaultSlowQueryCapacity
+	}
+	return &slowLog{entries: make([]SlowQuery, capacity)}
+}
+
+func (l *slowLog) add(query SlowQuery) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries[l.next] = query
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// slowest 按耗时降序返回最近的慢操作
+func (l *slowLog) slowest() []SlowQuery {
+	l.mutex.Lock()
+	count := l.next
+	if l.full {
+		count = len(l.entries)
+	}
+	result := append([]SlowQuery(nil), l.entries[:count]...)
+	l.mutex.Unlock()
+	sort.SliceStable(result, func(x, y int) bool {
+		return result[x].Duration > result[y].Duration
+	})
+	return result
+}
+
+// reportSlow 耗时超过数据源阈值时记录并发送到 sink,未设置 sink 时输出 WARN 日志
+func (client *MongoClient) reportSlow(ctx context.Context, op *Operation, err error) {
+	if client.slow == nil || op.Duration < client.options.slowThreshold {
+		return
+	}
+	query := SlowQuery{
+		Datasource: op.Datasource,
+		Database:   op.Database,
+		Collection: op.Collection,
+		Operation:  op.Name,
+		Duration:   op.Duration,
+		Method:     callerMethod(),
+		Time:       op.Start,
+		Err:        err,
+	}
+	if op.Filter != nil {
+		query.Filter = client.redactFilter(op.Filter)
+	}
+	if op.Pipeline != nil {
+		query.Pipeline = client.redactFilter(op.Pipeline)
+	}
+	if findOption, ok := op.Options.(*options.FindOptions); ok && findOption != nil {
+		query.Sort = findOption.Sort
+	}
+	client.slow.add(query)
+	if sink := client.options.slowSink; sink != nil {
+		sink(ctx, query)
+		return
+	}
+	client.log(ctx, LogWarn, "mongodb slow operation",
+		LogField{Key: LogFieldCollection, Value: query.Collection},
+		LogField{Key: LogFieldOp, Value: query.Operation},
+		LogField{Key: LogFieldDuration, Value: query.Duration},
+		LogField{Key: LogFieldFilter, Value: query.Filter},
+		LogField{Key: "method", Value: query.Method})
+}
+
+// SlowQueries 数据源最近的慢操作,按耗时降序,未设置 SlowThreshold 时返回 nil
+func (client *MongoClient) SlowQueries() []SlowQuery {
+	if client.slow == nil {
+		return nil
+	}
+	return client.slow.slowest()
+}
+
+func (i *MongodbDatabase) SlowQueries() []SlowQuery {
+	return i.client.SlowQueries()
+}
+
+// GetSlowQueries 指定数据源最近的慢操作,按耗时降序
+func GetSlowQueries(datasourceId string) ([]SlowQuery, error) {
+	database, err := GetDefaultManager().GetDatabaseById(datasourceId)
+	if nil != err {
+		return nil, err
+	}
+	return database.SlowQueries(), nil
+}
+
+var packagePath = reflect.TypeOf(MongoClient{}).PkgPath()
+
+// callerMethod 调用栈中最外层的包内导出函数或方法,即调用方直接使用的仓库方法
+func callerMethod() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	method := ""
+	for {
+		frame, more := frames.Next()
+		name := frame.Function
+		if strings.HasPrefix(name, packagePath+".") {
+			if short := methodName(strings.TrimPrefix(name, packagePath+".")); short != "" {
+				method = short
+			}
+		} else if method != "" {
+			break
+		}
+		if !more {
+			break
+		}
+	}
+	return method
+}
+
+// methodName 将 (*MongodbGeneric[...]).GetById 转换为 MongodbGeneric.GetById,非导出函数与闭包返回空
+func methodName(name string) string {
+	parts := strings.Split(strings.ReplaceAll(name, "[...]", ""), ".")
+	last := parts[len(parts)-1]
+	if last == "" || last[0] < 'A' || last[0] > 'Z' {
+		return ""
+	}
+	if len(parts) == 1 {
+		return last
+	}
+	receiver := strings.TrimSuffix(strings.TrimPrefix(parts[0], "(*"), ")")
+	if len(parts) > 2 || receiver == "" || receiver[0] < 'A' || receiver[0] > 'Z' {
+		return ""
+	}
+	return receiver + "." + last
+}
@@ -0,0 +1,87 @@
+package mongokits
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func durations(queries []SlowQuery) []time.Duration {
+	result := make([]time.Duration, len(queries))
+	for index, query := range queries {
+		result[index] = query.Duration
+	}
+	return result
+}
+
+// 容量满后覆盖最早的记录,结果按耗时降序
+func TestSlowLogRing(t *testing.T) {
+	log := newSlowLog(3)
+	if len(log.slowest()) != 0 {
+		t.Fatal("empty log should return nothing")
+	}
+	for _, d := range []time.Duration{5, 1, 3} {
+		log.add(SlowQuery{Duration: d})
+	}
+	if got := durations(log.slowest()); !reflect.DeepEqual(got, []time.Duration{5, 3, 1}) {
+		t.Fatalf("slowest = %v", got)
+	}
+	for _, d := range []time.Duration{2, 4} {
+		log.add(SlowQuery{Duration: d})
+	}
+	if got := durations(log.slowest()); !reflect.DeepEqual(got, []time.Duration{4, 3, 2}) {
+		t.Fatalf("oldest entries should be overwritten, got %v", got)
+	}
+	if len(newSlowLog(0).entries) != defaultSlowQueryCapacity {
+		t.Fatal("default capacity not applied")
+	}
+}
+
+// 超过阈值的操作记录到环形缓冲并发送到 sink,条件隐藏敏感字段
+func TestReportSlow(t *testing.T) {
+	var received []SlowQuery
+	ops := (&MongoOptions{}).Name("slow").SlowThreshold(10 * time.Millisecond).SlowQueryCapacity(2).
+		SlowQuerySink(func(ctx context.Context, query SlowQuery) {
+			received = append(received, query)
+		})
+	client := &MongoClient{options: ops, slow: newSlowLog(ops.slowCapacity)}
+
+	sort := bson.D{{Key: "created_at", Value: -1}}
+	client.reportSlow(context.Background(), &Operation{Name: OpFind, Collection: "users", Duration: time.Millisecond}, nil)
+	client.reportSlow(context.Background(), &Operation{
+		Name:       OpFind,
+		Collection: "users",
+		Filter:     bson.M{"password": "p", "name": "a"},
+		Options:    options.Find().SetSort(sort),
+		Duration:   20 * time.Millisecond,
+	}, nil)
+	if len(received) != 1 || len(client.SlowQueries()) != 1 {
+		t.Fatalf("received %d recorded %d", len(received), len(client.SlowQueries()))
+	}
+	query := received[0]
+	if !reflect.DeepEqual(query.Filter, bson.M{"password": redacted, "name": "a"}) || !reflect.DeepEqual(query.Sort, sort) {
+		t.Fatalf("unexpected query %+v", query)
+	}
+	if (&MongoClient{}).SlowQueries() != nil {
+		t.Fatal("client without threshold should not record")
+	}
+}
+
+func TestMethodName(t *testing.T) {
+	for name, want := range map[string]string{
+		"(*MongodbGeneric[...]).GetById":      "MongodbGeneric.GetById",
+		"(*MongodbDatabase).Insert":           "MongodbDatabase.Insert",
+		"GetByIdContext[...]":                 "GetByIdContext",
+		"queryAll[...]":                       "",
+		"(*MongodbGeneric[...]).cached.func1": "",
+		"(*slowLog).add":                      "",
+	} {
+		if got := methodName(name); got != want {
+			t.Errorf("methodName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
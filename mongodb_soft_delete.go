@@ -0,0 +1,164 @@
+package mongokits
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// SoftDeletable Table 实现该接口后,Delete 只记录删除时间,查询时自动排除已删除的文档
+// DeletedAtField 返回记录删除时间的字段名(bson 名称)
+type SoftDeletable interface {
+	DeletedAtField() string
+}
+
+type deleteScope int
+
+const (
+	scopeExcludeDeleted deleteScope = iota
+	scopeWithDeleted
+	scopeOnlyDeleted
+)
+
+func softDeleteField[T Table]() (string, bool) {
+	var r T
+	if sd, ok := lookupHook[SoftDeletable](r, &r); ok {
+		return sd.DeletedAtField(), true
+	}
+	return "", false
+}
+
+// scopeFilter 根据删除范围追加过滤条件,未实现 SoftDeletable 的 Table 原样返回
+func scopeFilter[T Table](scope deleteScope, cond interface{}) interface{} {
+	field, ok := softDeleteField[T]()
+	if !ok || scope == scopeWithDeleted {
+		return cond
+	}
+	var value interface{}
+	if scope == scopeOnlyDeleted {
+		value = bson.M{"$ne": nil}
+	}
+
+	return mergeFilter(cond, field, value)
+}
+
+// scopePipeline 在聚合管道前追加删除范围的 $match
+func scopePipeline[T Table](scope deleteScope, pipeline mongo.Pipeline) mongo.Pipeline {
+	if _, ok := softDeleteField[T](); !ok || scope == scopeWithDeleted {
+		return pipeline
+	}
+	match := bson.D{{Key: "$match", Value: scopeFilter[T](scope, bson.M{})}}
+	return append(mongo.Pipeline{match}, pipeline...)
+}
+
+func deleteByIds[T Table](ctx context.Context, database *MongodbDatabase, ids []string, hard bool) error {
+	var r T
+	oid, err := parseObjectIds(ids)
+	if err != nil {
+		return err
+	}
+	filter, err := tenantFilter[T](ctx, bson.M{"_id": bson.M{"$in": oid}})
+	if err != nil {
+		return err
+	}
+	if err := beforeDelete[T](ctx, database, filter); err != nil {
+		return err
+	}
+	field, soft := softDeleteField[T]()
+	if hard || !soft {
+		_, err = database.deleteMany(ctx, r.TableName(), filter)
+		return wrapError(err)
+	}
+	_, err = database.updateMany(ctx, r.TableName(), mergeFilter(filter, field, nil), bson.M{"$set": bson.M{field: time.Now()}})
+	return wrapError(err)
+}
+
+func restoreByIds[T Table](ctx context.Context, database *MongodbDatabase, ids []string) error {
+	var r T
+	field, ok := softDeleteField[T]()
+	if !ok {
+		return nil
+	}
+	oid, err := parseObjectIds(ids)
+	if err != nil {
+		return err
+	}
+	filter, err := tenantFilter[T](ctx, bson.M{"_id": bson.M{"$in": oid}})
+	if err != nil {
+		return err
+	}
+	_, err = database.updateMany(ctx, r.TableName(), filter, bson.M{"$unset": bson.M{field: ""}})
+	return wrapError(err)
+}
+
+// WithDeleted 返回包含已删除文档的查询
+func (i *MongodbGeneric[T]) WithDeleted() *MongodbGeneric[T] {
+	g := *i
+	g.scope = scopeWithDeleted
+	return &g
+}
+
+// OnlyDeleted 返回只查询已删除文档的查询
+func (i *MongodbGeneric[T]) OnlyDeleted() *MongodbGeneric[T] {
+	g := *i
+	g.scope = scopeOnlyDeleted
+	return &g
+}
+
+func (i *MongodbGeneric[T]) Restore(ids ...string) error {
+	defer i.invalidate(stringIds(ids)...)
+	return restoreByIds[T](i.getCtx(), i.database, ids)
+}
+
+// HardDelete 物理删除文档,不考虑 SoftDeletable
+func (i *MongodbGeneric[T]) HardDelete(ids ...string) error {
+	defer i.invalidate(stringIds(ids)...)
+	return deleteByIds[T](i.getCtx(), i.database, ids, true)
+}
+
+func (i *MongodbGenericComplex[T]) WithDeleted() *MongodbGenericComplex[T] {
+	g := *i
+	g.scope = scopeWithDeleted
+	return &g
+}
+
+func (i *MongodbGenericComplex[T]) OnlyDeleted() *MongodbGenericComplex[T] {
+	g := *i
+	g.scope = scopeOnlyDeleted
+	return &g
+}
+
+func (i *MongodbGenericComplex[T]) Restore(ids ...string) error {
+	defer i.markWrite()
+	return restoreByIds[T](i.getCtx(), i.writer, ids)
+}
+
+func (i *MongodbGenericComplex[T]) HardDelete(ids ...string) error {
+	defer i.markWrite()
+	return deleteByIds[T](i.getCtx(), i.writer, ids, true)
+}
+
+func Restore[T Table](ids ...string) error {
+	return RestoreContext[T](context.TODO(), ids...)
+}
+
+func RestoreContext[T Table](ctx context.Context, ids ...string) error {
+	database, err := defaultDatabase[T](ctx)
+	if err != nil {
+		return err
+	}
+	return restoreByIds[T](ctx, database, ids)
+}
+
+func HardDelete[T Table](ids ...string) error {
+	return HardDeleteContext[T](context.TODO(), ids...)
+}
+
+func HardDeleteContext[T Table](ctx context.Context, ids ...string) error {
+	database, err := defaultDatabase[T](ctx)
+	if err != nil {
+		return err
+	}
+	return deleteByIds[T](ctx, database, ids, true)
+}
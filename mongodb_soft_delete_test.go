@@ -0,0 +1,96 @@
+package mongokits
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// valueSoftItem 值类型的表,DeletedAtField 以指针接收者实现
+type valueSoftItem struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	DeletedAt *time.Time         `bson:"removed_at"`
+}
+
+func (s valueSoftItem) TableName() string       { return "test_value_soft_items" }
+func (s valueSoftItem) PrimaryKey() interface{} { return s.Id }
+func (s valueSoftItem) PrimaryKeyName() string  { return "_id" }
+func (s *valueSoftItem) DeletedAtField() string { return "removed_at" }
+
+func TestSoftDeleteField(t *testing.T) {
+	if field, ok := softDeleteField[valueSoftItem](); !ok || field != "removed_at" {
+		t.Fatalf("value table: %q %v", field, ok)
+	}
+	if field, ok := softDeleteField[*softItem](); !ok || field != "deleted_at" {
+		t.Fatalf("pointer table: %q %v", field, ok)
+	}
+	if _, ok := softDeleteField[*testItem](); ok {
+		t.Fatal("plain table should not be soft deletable")
+	}
+}
+
+func TestScopeFilter(t *testing.T) {
+	cond := bson.M{"name": "a"}
+	for _, c := range []struct {
+		scope deleteScope
+		want  interface{}
+	}{
+		{scopeExcludeDeleted, bson.M{"name": "a", "removed_at": nil}},
+		{scopeOnlyDeleted, bson.M{"name": "a", "removed_at": bson.M{"$ne": nil}}},
+		{scopeWithDeleted, cond},
+	} {
+		if got := scopeFilter[valueSoftItem](c.scope, cond); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("scope %d: %v, want %v", c.scope, got, c.want)
+		}
+	}
+	if got := scopeFilter[*testItem](scopeExcludeDeleted, cond); !reflect.DeepEqual(got, cond) {
+		t.Errorf("plain table filter changed: %v", got)
+	}
+	if len(cond) != 1 {
+		t.Errorf("caller filter was modified: %v", cond)
+	}
+	pipeline := scopePipeline[valueSoftItem](scopeExcludeDeleted, nil)
+	if len(pipeline) != 1 || !reflect.DeepEqual(pipeline[0], bson.D{{Key: "$match", Value: bson.M{"removed_at": nil}}}) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+}
+
+// 软删除的表 Delete 转换为设置删除时间的更新,HardDelete 物理删除,Restore 清除删除时间
+func TestDeleteByIds(t *testing.T) {
+	var ops []*Operation
+	database := newFakeDatabase(t, func(ctx context.Context, op *Operation, next Invoker) error {
+		ops = append(ops, op)
+		return nil
+	})
+	id := primitive.NewObjectID()
+	ctx := context.Background()
+	if err := deleteByIds[valueSoftItem](ctx, database, []string{id.Hex()}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteByIds[valueSoftItem](ctx, database, []string{id.Hex()}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreByIds[valueSoftItem](ctx, database, []string{id.Hex()}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 3 || ops[0].Name != OpUpdateMany || ops[1].Name != OpDeleteMany || ops[2].Name != OpUpdateMany {
+		t.Fatalf("unexpected operations %v", ops)
+	}
+	ids := bson.M{"$in": []primitive.ObjectID{id}}
+	if !reflect.DeepEqual(ops[0].Filter, bson.M{"_id": ids, "removed_at": nil}) {
+		t.Errorf("soft delete filter %v", ops[0].Filter)
+	}
+	if _, ok := ops[0].Update.(bson.M)["$set"].(bson.M)["removed_at"].(time.Time); !ok {
+		t.Errorf("soft delete update %v", ops[0].Update)
+	}
+	if !reflect.DeepEqual(ops[1].Filter, bson.M{"_id": ids}) {
+		t.Errorf("hard delete filter %v", ops[1].Filter)
+	}
+	if !reflect.DeepEqual(ops[2].Update, bson.M{"$unset": bson.M{"removed_at": ""}}) {
+		t.Errorf("restore update %v", ops[2].Update)
+	}
+}
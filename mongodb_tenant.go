@@ -0,0 +1,192 @@
+package mongokits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+*
+TenantScoped Table 实现该接口后启用共享集合模式的租户隔离:
+查询、统计、聚合、按条件更新与删除自动追加租户条件,新增与整文档更新时自动填充租户字段
+TenantField 返回租户字段名(bson 名称),ctx 中没有租户(WithTenant)时操作返回 ErrorTenantRequired
+*/
+type TenantScoped interface {
+	TenantField() string
+	GetTenantId() string
+	SetTenantId(tenantId string)
+}
+
+// TenantResolver 每租户独立数据库模式下返回租户使用的数据源 id 与数据库名
+// 数据源 id 为空时使用默认数据源,数据库名为空时使用数据源配置的数据库
+type TenantResolver func(tenantId string) (datasourceId string, databaseName string, err error)
+
+type tenantKey struct{}
+
+type crossTenantKey struct{}
+
+// WithTenant 在 context 中记录当前租户
+func WithTenant(ctx context.Context, tenantId string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantId)
+}
+
+func TenantFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	tenantId, ok := ctx.Value(tenantKey{}).(string)
+	return tenantId, ok && tenantId != ""
+}
+
+// WithCrossTenant 显式声明跨租户访问:查询与更新不再追加租户条件,包级函数不再按租户选择数据库
+// 新增的文档未设置租户时仍使用 ctx 中的租户,仅用于后台任务、运维等确需访问所有租户的场景
+func WithCrossTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, crossTenantKey{}, true)
+}
+
+func isCrossTenant(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	cross, _ := ctx.Value(crossTenantKey{}).(bool)
+	return cross
+}
+
+// tenantScope 返回需要追加的租户条件,T 未实现 TenantScoped 或跨租户访问时 scoped 为 false
+func tenantScope[T Table](ctx context.Context) (field string, tenantId string, scoped bool, err error) {
+	var r T
+	t, ok := lookupHook[TenantScoped](r, &r)
+	if !ok || isCrossTenant(ctx) {
+		return "", "", false, nil
+	}
+	tenantId, exists := TenantFromContext(ctx)
+	if !exists {
+		return "", "", false, newKindError(ErrorTenantRequired, fmt.Errorf("table %s is tenant scoped", r.TableName()))
+	}
+	return t.TenantField(), tenantId, true, nil
+}
+
+// tenantFilter 为查询条件追加租户条件
+func tenantFilter[T Table](ctx context.Context, cond interface{}) (interface{}, error) {
+	field, tenantId, scoped, err := tenantScope[T](ctx)
+	if err != nil || !scoped {
+		return cond, err
+	}
+	return mergeFilter(cond, field, tenantId), nil
+}
+
+// tenantPipeline 在聚合管道前追加租户的 $match
+func tenantPipeline[T Table](ctx context.Context, pipeline mongo.Pipeline) (mongo.Pipeline, error) {
+	field, tenantId, scoped, err := tenantScope[T](ctx)
+	if err != nil || !scoped {
+		return pipeline, err
+	}
+	match := bson.D{{Key: "$match", Value: bson.M{field: tenantId}}}
+	return append(mongo.Pipeline{match}, pipeline...), nil
+}
+
+// tenantSetter 按字段更新不允许修改租户字段,跨租户访问除外
+func tenantSetter[T Table](ctx context.Context, update interface{}) error {
+	field, _, scoped, err := tenantScope[T](ctx)
+	if err != nil || !scoped {
+		return err
+	}
+	if m, ok := update.(bson.M); ok && fieldAssigned(m, field) {
+		return newKindError(ErrorTenantMismatch, fmt.Errorf("tenant field %s can not be updated", field))
+	}
+	return nil
+}
+
+// stampTenant 文档未设置租户时填充 ctx 中的租户,已属于其他租户时返回 ErrorTenantMismatch(跨租户访问除外)
+func stampTenant(ctx context.Context, doc interface{}, ptr interface{}) error {
+	t, ok := lookupHook[TenantScoped](doc, ptr)
+	if !ok {
+		return nil
+	}
+	tenantId, exists := TenantFromContext(ctx)
+	current := t.GetTenantId()
+	switch {
+	case current == "" && exists:
+		t.SetTenantId(tenantId)
+	case current == "":
+		return ErrorTenantRequired
+	case isCrossTenant(ctx):
+	case !exists:
+		return ErrorTenantRequired
+	case current != tenantId:
+		return newKindError(ErrorTenantMismatch, fmt.Errorf("document tenant %s,context tenant %s", current, tenantId))
+	}
+	return nil
+}
+
+// tenantCondition 整文档更新时以文档的租户作为条件,文档的租户已在 prepareUpdate 中与 ctx 校验
+func tenantCondition(doc interface{}, ptr interface{}, filter bson.M) {
+	if t, ok := lookupHook[TenantScoped](doc, ptr); ok {
+		filter[t.TenantField()] = t.GetTenantId()
+	}
+}
+
+// mergeFilter 为条件追加 field = value,条件中已存在该字段时使用 $and 合并
+func mergeFilter(cond interface{}, field string, value interface{}) interface{} {
+	filter, ok := cond.(bson.M)
+	if !ok && cond != nil {
+		return bson.M{"$and": bson.A{cond, bson.M{field: value}}}
+	}
+	if _, exists := filter[field]; exists {
+		return bson.M{"$and": bson.A{filter, bson.M{field: value}}}
+	}
+	merged := bson.M{field: value}
+	for k, v := range filter {
+		merged[k] = v
+	}
+	return merged
+}
+
+// withDatabaseName 返回使用同一客户端下另一个数据库的副本
+func (i *MongodbDatabase) withDatabaseName(name string) *MongodbDatabase {
+	if name == "" || name == i.GetRaw().Name() {
+		return i
+	}
+	d := *i
+	c := *i.client
+	c.database = i.client.database.Client().Database(name)
+	d.client = &c
+	return &d
+}
+
+// SetTenantResolver 为默认的 DatabaseManager 设置每租户独立数据库模式的解析器
+func SetTenantResolver(resolver TenantResolver) error {
+	manager, ok := GetDefaultManager().(TenantDatabaseManager)
+	if !ok {
+		return errors.New("database manager does not support tenant databases")
+	}
+	manager.SetTenantResolver(resolver)
+	return nil
+}
+
+// contextDatabase 按 ctx 中的租户选择数据源,ctx 中没有租户、跨租户访问或管理器不支持租户数据库时使用默认数据源
+func contextDatabase(ctx context.Context) (*MongodbDatabase, error) {
+	manager := GetDefaultManager()
+	if tenantId, ok := TenantFromContext(ctx); ok && !isCrossTenant(ctx) {
+		if tenants, ok := manager.(TenantDatabaseManager); ok {
+			return tenants.GetTenantDatabase(tenantId)
+		}
+	}
+	return manager.GetDatabase()
+}
+
+// GetTenantGenericDatabase 按 ctx 中的租户选择数据源(SetTenantResolver)并绑定 ctx
+func GetTenantGenericDatabase[T Table](ctx context.Context) (*MongodbGeneric[T], error) {
+	var r T
+	db, err := tableDatabase(ctx, r, &r)
+	if nil != err {
+		return nil, err
+	}
+	g := &MongodbGeneric[T]{
+		database: db,
+	}
+	return g.WithContext(ctx), nil
+}
@@ -0,0 +1,187 @@
+package mongokits
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"time"
+)
+
+// Timestamped Table 实现该接口后,写入时自动设置创建时间,更新时自动设置更新时间
+// TimestampFields 返回创建时间与更新时间的字段名(bson 名称),用于 UpdateSet 等按字段更新的场景
+type Timestamped interface {
+	TimestampFields() (createdAt string, updatedAt string)
+	GetCreatedAt() time.Time
+	SetCreatedAt(t time.Time)
+	SetUpdatedAt(t time.Time)
+}
+
+// Audited Table 实现该接口后,从 context 中读取操作人(WithPrincipal)设置创建人与更新人
+type Audited interface {
+	AuditFields() (createdBy string, updatedBy string)
+	GetCreatedBy() string
+	SetCreatedBy(principal string)
+	SetUpdatedBy(principal string)
+}
+
+type principalKey struct{}
+
+// WithPrincipal 在 context 中记录当前操作人
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	principal, ok := ctx.Value(principalKey{}).(string)
+	return principal, ok && principal != ""
+}
+
+// touch 设置时间与操作人字段,创建时间和创建人只在为空时设置,因此 upsert 新增的文档同样会被填充
+func touch(ctx context.Context, doc interface{}, ptr interface{}, now time.Time) {
+	if ts, ok := lookupHook[Timestamped](doc, ptr); ok {
+		if ts.GetCreatedAt().IsZero() {
+			ts.SetCreatedAt(now)
+		}
+		ts.SetUpdatedAt(now)
+	}
+	if audited, ok := lookupHook[Audited](doc, ptr); ok {
+		if principal, exists := PrincipalFromContext(ctx); exists {
+			if audited.GetCreatedBy() == "" {
+				audited.SetCreatedBy(principal)
+			}
+			audited.SetUpdatedBy(principal)
+		}
+	}
+}
+
+// prepareInsert 依次填充租户、调用 BeforeInsert 钩子与时间戳填充,ptr 为 doc 的地址,与批量写入一致地支持值类型文档
+func prepareInsert(ctx context.Context, doc interface{}, ptr interface{}) error {
+	if err := stampTenant(ctx, doc, ptr); err != nil {
+		return err
+	}
+	if err := beforeInsert(ctx, doc, ptr); err != nil {
+		return err
+	}
+	touch(ctx, doc, ptr, time.Now())
+	return nil
+}
+
+func prepareUpdate(ctx context.Context, doc interface{}, ptr interface{}) error {
+	if err := stampTenant(ctx, doc, ptr); err != nil {
+		return err
+	}
+	if err := beforeUpdate(ctx, doc, ptr); err != nil {
+		return err
+	}
+	touch(ctx, doc, ptr, time.Now())
+	return nil
+}
+
+func prepareInsertAll[T Table](ctx context.Context, docs []T) error {
+	for index := range docs {
+		if err := stampTenant(ctx, docs[index], &docs[index]); err != nil {
+			return err
+		}
+	}
+	if err := beforeInsertAll(ctx, docs); err != nil {
+		return err
+	}
+	now := time.Now()
+	for index := range docs {
+		touch(ctx, docs[index], &docs[index], now)
+	}
+	return nil
+}
+
+func prepareUpdateAll[T Table](ctx context.Context, docs []T) error {
+	for index := range docs {
+		if err := stampTenant(ctx, docs[index], &docs[index]); err != nil {
+			return err
+		}
+	}
+	if err := beforeUpdateAll(ctx, docs); err != nil {
+		return err
+	}
+	now := time.Now()
+	for index := range docs {
+		touch(ctx, docs[index], &docs[index], now)
+	}
+	return nil
+}
+
+// touchSetter 为按字段更新的语句注入更新时间($currentDate)与更新人,upsert 时通过 $setOnInsert 设置创建字段
+func touchSetter[T Table](ctx context.Context, setter interface{}, upsert bool) interface{} {
+	update, ok := setter.(bson.M)
+	if !ok {
+		return setter
+	}
+	var r T
+	ts, timestamped := lookupHook[Timestamped](r, &r)
+	audited, isAudited := lookupHook[Audited](r, &r)
+	principal, hasPrincipal := PrincipalFromContext(ctx)
+	if !timestamped && !(isAudited && hasPrincipal) {
+		return setter
+	}
+
+	result := bson.M{}
+	for k, v := range update {
+		result[k] = v
+	}
+	if timestamped {
+		createdAt, updatedAt := ts.TimestampFields()
+		if updatedAt != "" && !fieldAssigned(result, updatedAt) {
+			setOperatorField(result, "$currentDate", updatedAt, true)
+		}
+		if upsert && createdAt != "" && !fieldAssigned(result, createdAt) {
+			setOperatorField(result, "$setOnInsert", createdAt, time.Now())
+		}
+	}
+	if isAudited && hasPrincipal {
+		createdBy, updatedBy := audited.AuditFields()
+		if updatedBy != "" && !fieldAssigned(result, updatedBy) {
+			setOperatorField(result, "$set", updatedBy, principal)
+		}
+		if upsert && createdBy != "" && !fieldAssigned(result, createdBy) {
+			setOperatorField(result, "$setOnInsert", createdBy, principal)
+		}
+	}
+	return result
+}
+
+// fieldAssigned 判断更新语句是否已经显式设置了该字段
+func fieldAssigned(update bson.M, field string) bool {
+	for _, operator := range []string{"$set", "$setOnInsert", "$currentDate", "$unset", "$inc"} {
+		switch fields := update[operator].(type) {
+		case bson.M:
+			if _, exists := fields[field]; exists {
+				return true
+			}
+		case bson.D:
+			for _, e := range fields {
+				if e.Key == field {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// setOperatorField 在更新操作符下追加字段,不修改调用方传入的原始对象
+func setOperatorField(update bson.M, operator string, field string, value interface{}) {
+	switch fields := update[operator].(type) {
+	case bson.M:
+		merged := bson.M{field: value}
+		for k, v := range fields {
+			merged[k] = v
+		}
+		update[operator] = merged
+	case bson.D:
+		merged := append(bson.D{}, fields...)
+		update[operator] = append(merged, bson.E{Key: field, Value: value})
+	default:
+		update[operator] = bson.M{field: value}
+	}
+}
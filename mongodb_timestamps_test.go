@@ -0,0 +1,91 @@
+package mongokits
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// auditedItem 同时记录时间与操作人的表
+type auditedItem struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	CreatedAt time.Time          `bson:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+	CreatedBy string             `bson:"created_by"`
+	UpdatedBy string             `bson:"updated_by"`
+}
+
+func (a *auditedItem) TableName() string       { return "test_audited_items" }
+func (a *auditedItem) PrimaryKey() interface{} { return a.Id }
+func (a *auditedItem) PrimaryKeyName() string  { return "_id" }
+
+func (a *auditedItem) TimestampFields() (string, string) { return "created_at", "updated_at" }
+func (a *auditedItem) GetCreatedAt() time.Time           { return a.CreatedAt }
+func (a *auditedItem) SetCreatedAt(t time.Time)          { a.CreatedAt = t }
+func (a *auditedItem) SetUpdatedAt(t time.Time)          { a.UpdatedAt = t }
+
+func (a *auditedItem) AuditFields() (string, string) { return "created_by", "updated_by" }
+func (a *auditedItem) GetCreatedBy() string          { return a.CreatedBy }
+func (a *auditedItem) SetCreatedBy(principal string) { a.CreatedBy = principal }
+func (a *auditedItem) SetUpdatedBy(principal string) { a.UpdatedBy = principal }
+
+// 创建时间与创建人只在为空时设置,更新时间与更新人每次覆盖,ctx 中没有操作人时不设置操作人
+func TestTouch(t *testing.T) {
+	doc := &auditedItem{}
+	created := time.Now().Add(-time.Hour)
+	touch(context.Background(), doc, &doc, created)
+	if !doc.CreatedAt.Equal(created) || !doc.UpdatedAt.Equal(created) || doc.CreatedBy != "" || doc.UpdatedBy != "" {
+		t.Fatalf("unexpected insert fields %+v", doc)
+	}
+
+	updated := time.Now()
+	touch(WithPrincipal(context.Background(), "alice"), doc, &doc, updated)
+	if !doc.CreatedAt.Equal(created) || !doc.UpdatedAt.Equal(updated) || doc.CreatedBy != "alice" || doc.UpdatedBy != "alice" {
+		t.Fatalf("unexpected update fields %+v", doc)
+	}
+	touch(WithPrincipal(context.Background(), "bob"), doc, &doc, updated)
+	if doc.CreatedBy != "alice" || doc.UpdatedBy != "bob" {
+		t.Fatalf("created by should be kept: %+v", doc)
+	}
+}
+
+func TestTouchSetter(t *testing.T) {
+	ctx := WithPrincipal(context.Background(), "alice")
+	update := bson.M{"$set": bson.M{"name": "a"}}
+	got := touchSetter[*auditedItem](ctx, update, false).(bson.M)
+	want := bson.M{
+		"$set":         bson.M{"name": "a", "updated_by": "alice"},
+		"$currentDate": bson.M{"updated_at": true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("touchSetter = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(update, bson.M{"$set": bson.M{"name": "a"}}) {
+		t.Fatalf("caller update was modified: %v", update)
+	}
+
+	upsert := touchSetter[*auditedItem](ctx, bson.M{"$set": bson.M{"updated_at": time.Time{}}}, true).(bson.M)
+	onInsert := upsert["$setOnInsert"].(bson.M)
+	if _, ok := onInsert["created_at"].(time.Time); !ok || onInsert["created_by"] != "alice" {
+		t.Fatalf("upsert should set created fields on insert: %v", upsert)
+	}
+	if _, exists := upsert["$currentDate"]; exists {
+		t.Fatalf("explicit updated_at should be kept: %v", upsert)
+	}
+
+	anonymous := touchSetter[*auditedItem](context.Background(), bson.M{"$inc": bson.M{"n": 1}}, false).(bson.M)
+	if _, exists := anonymous["$set"]; exists {
+		t.Fatalf("updated_by set without principal: %v", anonymous)
+	}
+	if plain := touchSetter[*testItem](ctx, update, false); !reflect.DeepEqual(plain, update) {
+		t.Fatalf("plain table update changed: %v", plain)
+	}
+	pipeline := bson.A{bson.M{"$set": bson.M{"name": "a"}}}
+	if got := touchSetter[*auditedItem](ctx, pipeline, false); !reflect.DeepEqual(got, pipeline) {
+		t.Fatalf("non bson.M update changed: %v", got)
+	}
+}
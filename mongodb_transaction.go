@@ -0,0 +1,187 @@
+package mongokits
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+	"time"
+)
+
+const defaultTransactionRetryTimeout = 120 * time.Second
+
+type transactionKey struct{}
+
+type TransactionOptions struct {
+	options      *options.TransactionOptions
+	retryTimeout time.Duration
+}
+
+func NewTransactionOptions() *TransactionOptions {
+	return &TransactionOptions{
+		options:      options.Transaction(),
+		retryTimeout: defaultTransactionRetryTimeout,
+	}
+}
+
+func (op *TransactionOptions) ReadConcern(rc *readconcern.ReadConcern) *TransactionOptions {
+	op.options.SetReadConcern(rc)
+	return op
+}
+
+func (op *TransactionOptions) WriteConcern(wc *writeconcern.WriteConcern) *TransactionOptions {
+	op.options.SetWriteConcern(wc)
+	return op
+}
+
+func (op *TransactionOptions) ReadPreference(rp *readpref.ReadPref) *TransactionOptions {
+	op.options.SetReadPreference(rp)
+	return op
+}
+
+func (op *TransactionOptions) MaxCommitTime(mct time.Duration) *TransactionOptions {
+	op.options.SetMaxCommitTime(&mct)
+	return op
+}
+
+// RetryTimeout 遇到 TransientTransactionError/UnknownTransactionCommitResult 时重试的最长时间,默认 120 秒
+func (op *TransactionOptions) RetryTimeout(timeout time.Duration) *TransactionOptions {
+	op.retryTimeout = timeout
+	return op
+}
+
+// inTransaction 判断 ctx 是否处于 client 上由 WithTransaction 开启的事务中
+func inTransaction(ctx context.Context, client *mongo.Client) bool {
+	if ctx == nil {
+		return false
+	}
+	owner, ok := ctx.Value(transactionKey{}).(*mongo.Client)
+	return ok && owner == client
+}
+
+// transactionClient ctx 处于其他客户端的事务中时返回 ErrorTransactionClient,
+// 避免其他数据源(Datasourced、每租户独立数据源)的操作因会话不属于该客户端而失败或脱离事务执行
+func transactionClient(ctx context.Context, client *mongo.Client) error {
+	if ctx == nil {
+		return nil
+	}
+	owner, ok := ctx.Value(transactionKey{}).(*mongo.Client)
+	if ok && owner != client {
+		return ErrorTransactionClient
+	}
+	return nil
+}
+
+func hasErrorLabel(err error, label string) bool {
+	var ce mongo.CommandError
+	if errors.As(err, &ce) {
+		return ce.HasErrorLabel(label)
+	}
+	var de driver.Error
+	if errors.As(err, &de) {
+		return de.HasErrorLabel(label)
+	}
+	return false
+}
+
+/*
+*
+在事务中执行 fn,fn 中使用 txCtx 的调用加入同一会话:
+MongodbGeneric[T].WithContext(txCtx)、MongodbDatabase.WithContext(txCtx) 以及包级函数的 Context 版本(如 UpdateContext)
+fn 返回 TransientTransactionError 时整个事务重试,提交返回 UnknownTransactionCommitResult 时重试提交,因此 fn 可能被执行多次
+ctx 已处于同一数据源的事务中时直接在该事务中执行 fn,事务中对其他客户端的操作返回 ErrorTransactionClient
+*/
+func (i *MongodbDatabase) WithTransaction(ctx context.Context, fn func(txCtx context.Context) error, ops ...*TransactionOptions) error {
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	client := i.GetRaw().Client()
+	if inTransaction(ctx, client) {
+		return fn(ctx)
+	}
+	if err := transactionClient(ctx, client); err != nil {
+		return err
+	}
+	op := NewTransactionOptions()
+	if len(ops) > 0 && ops[0] != nil {
+		op = ops[0]
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return wrapError(err)
+	}
+	defer session.EndSession(context.Background())
+
+	deadline := time.Now().Add(op.retryTimeout)
+	return mongo.WithSession(ctx, session, func(sc mongo.SessionContext) error {
+		txCtx := context.WithValue(sc, transactionKey{}, client)
+		for {
+			if err := session.StartTransaction(op.options); err != nil {
+				return wrapError(err)
+			}
+			if err := fn(txCtx); err != nil {
+				_ = session.AbortTransaction(context.Background())
+				if hasErrorLabel(err, driver.TransientTransactionError) && time.Now().Before(deadline) {
+					continue
+				}
+				return err
+			}
+			err := commitTransaction(sc, session, deadline)
+			if err != nil && hasErrorLabel(err, driver.TransientTransactionError) && time.Now().Before(deadline) {
+				continue
+			}
+			return wrapError(err)
+		}
+	})
+}
+
+// commitTransaction 提交事务,结果未知时在 deadline 前重试提交
+func commitTransaction(ctx context.Context, session mongo.Session, deadline time.Time) error {
+	for {
+		err := session.CommitTransaction(ctx)
+		if err == nil {
+			return nil
+		}
+		var ce mongo.CommandError
+		if errors.As(err, &ce) && ce.HasErrorLabel(driver.UnknownTransactionCommitResult) &&
+			!ce.IsMaxTimeMSExpiredError() && time.Now().Before(deadline) {
+			continue
+		}
+		return err
+	}
+}
+
+// WithTransaction 在默认数据源上开启事务,Datasourced 的表或每租户独立数据源使用 WithTableTransaction
+func WithTransaction(ctx context.Context, fn func(txCtx context.Context) error, ops ...*TransactionOptions) error {
+	database, err := GetDefaultManager().GetDatabase()
+	if err != nil {
+		return err
+	}
+	return database.WithTransaction(ctx, fn, ops...)
+}
+
+// WithTableTransaction 在 T 所在的数据源上开启事务,与包级函数一致地按 Datasourced 与 ctx 中的租户选择数据源
+func WithTableTransaction[T Table](ctx context.Context, fn func(txCtx context.Context) error, ops ...*TransactionOptions) error {
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	database, err := defaultDatabase[T](ctx)
+	if err != nil {
+		return err
+	}
+	return database.WithTransaction(ctx, fn, ops...)
+}
+
+func (i *MongodbGeneric[T]) WithTransaction(fn func(txCtx context.Context) error, ops ...*TransactionOptions) error {
+	return i.database.WithTransaction(i.getCtx(), fn, ops...)
+}
+
+// WithTransaction 事务在写库上执行
+func (i *MongodbGenericComplex[T]) WithTransaction(fn func(txCtx context.Context) error, ops ...*TransactionOptions) error {
+	return i.writer.WithTransaction(i.getCtx(), fn, ops...)
+}
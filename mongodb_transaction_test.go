@@ -0,0 +1,71 @@
+package mongokits
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// orderItem 声明使用 orders 数据源的表
+type orderItem struct {
+	Id primitive.ObjectID `bson:"_id"`
+}
+
+func (o *orderItem) TableName() string       { return "test_orders" }
+func (o *orderItem) PrimaryKey() interface{} { return o.Id }
+func (o *orderItem) PrimaryKeyName() string  { return "_id" }
+func (o *orderItem) DatasourceId() string    { return "orders" }
+func (o *orderItem) DatabaseName() string    { return "" }
+
+// 事务中对其他客户端的操作直接返回 ErrorTransactionClient,不会脱离事务执行
+func TestTransactionClientMismatch(t *testing.T) {
+	var reached []string
+	record := func(ctx context.Context, op *Operation, next Invoker) error {
+		reached = append(reached, op.Datasource)
+		return nil
+	}
+	owner, other := newFakeDatabase(t, record), newFakeDatabase(t, record)
+	txCtx := context.WithValue(context.Background(), transactionKey{}, owner.GetRaw().Client())
+
+	if _, err := other.insertOne(txCtx, "items", &testItem{}); !IsTransactionClient(err) {
+		t.Fatalf("expected transaction client error, got %v", err)
+	}
+	if len(reached) != 0 {
+		t.Fatalf("operation on another client was executed: %v", reached)
+	}
+	if _, err := owner.insertOne(txCtx, "items", &testItem{}); err != nil || len(reached) != 1 {
+		t.Fatalf("operation on the transaction client: %v %v", err, reached)
+	}
+
+	called := false
+	fn := func(ctx context.Context) error {
+		called = true
+		return nil
+	}
+	if err := other.WithTransaction(txCtx, fn); !IsTransactionClient(err) || called {
+		t.Fatalf("nested transaction on another client: %v called=%v", err, called)
+	}
+	if err := owner.WithTransaction(txCtx, fn); err != nil || !called {
+		t.Fatalf("nested transaction on the same client: %v called=%v", err, called)
+	}
+}
+
+// WithTableTransaction 按 Datasourced 选择数据源,包级 WithTransaction 使用默认数据源
+func TestWithTableTransaction(t *testing.T) {
+	record := func(ctx context.Context, op *Operation, next Invoker) error { return nil }
+	primary, orders := newFakeDatabase(t, record), newFakeDatabase(t, record)
+	useFakeFactory(t, map[string]*MongodbDatabase{"": primary, "orders": orders})
+	txCtx := context.WithValue(context.Background(), transactionKey{}, orders.GetRaw().Client())
+
+	called := false
+	if err := WithTableTransaction[*orderItem](txCtx, func(ctx context.Context) error {
+		called = true
+		return nil
+	}); err != nil || !called {
+		t.Fatalf("table transaction should join the orders transaction: %v called=%v", err, called)
+	}
+	if err := WithTransaction(txCtx, func(ctx context.Context) error { return nil }); !IsTransactionClient(err) {
+		t.Fatalf("default datasource transaction should be rejected, got %v", err)
+	}
+}
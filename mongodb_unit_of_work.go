@@ -0,0 +1,224 @@
+package mongokits
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type unitWork interface {
+	flush(ctx context.Context, database *MongodbDatabase) error
+	rollback()
+}
+
+// tableWork 一个集合登记的变更,flush 时新增与更新合并为一次有序的批量写入,删除合并为一次按 id 删除
+type tableWork[T Table] struct {
+	inserts  []T
+	updates  []T
+	expected []int64
+	deletes  []string
+}
+
+func (w *tableWork[T]) flush(ctx context.Context, database *MongodbDatabase) error {
+	// 事务重试时重新执行,先恢复版本号
+	w.rollback()
+	if len(w.inserts)+len(w.updates) > 0 {
+		bulk := NewBulk[T](database)
+		for _, doc := range w.inserts {
+			bulk.InsertOne(doc)
+		}
+		versioned := false
+		for index := range w.updates {
+			filter := bson.M{"_id": w.updates[index].PrimaryKey()}
+			if v, ok := lookupHook[Versioned](w.updates[index], &w.updates[index]); ok {
+				versioned = true
+				filter[v.VersionField()] = w.expected[index]
+				v.SetVersion(w.expected[index] + 1)
+			}
+			bulk.ReplaceOne(filter, w.updates[index], false)
+		}
+		result, err := bulk.Execute(ctx)
+		if err != nil {
+			return err
+		}
+		if missing := int64(len(w.updates)) - result.MatchedCount; missing > 0 {
+			var r T
+			kind := ErrorDocumentNotFound
+			if versioned {
+				kind = ErrorVersionConflict
+			}
+			return newKindError(kind, fmt.Errorf("%d/%d documents of %s not matched", missing, len(w.updates), r.TableName()))
+		}
+	}
+	if len(w.deletes) > 0 {
+		return deleteByIds[T](ctx, database, w.deletes, false)
+	}
+	return nil
+}
+
+// rollback 将 Versioned 文档的版本号恢复为登记时的值
+func (w *tableWork[T]) rollback() {
+	for index := range w.updates {
+		if v, ok := lookupHook[Versioned](w.updates[index], &w.updates[index]); ok {
+			v.SetVersion(w.expected[index])
+		}
+	}
+}
+
+/*
+*
+UnitOfWork 收集多个仓库登记的新增、更新、删除,Commit 时在同一事务中按集合批量写入,任一失败则全部回滚
+所有仓库需使用同一数据源(同一客户端),数据源取自第一个登记的仓库
+*/
+type UnitOfWork struct {
+	database *MongodbDatabase
+	works    []unitWork
+	// tables 按集合名与类型索引 works,works 保持首次登记的顺序
+	tables map[string]unitWork
+	ops    *TransactionOptions
+	ctx    context.Context
+	err    error
+	// afterCommit 提交结束后执行(无论成功与否),用于失效仓库缓存、记录写入时间
+	afterCommit []func()
+}
+
+func NewUnitOfWork(ops ...*TransactionOptions) *UnitOfWork {
+	uow := &UnitOfWork{tables: make(map[string]unitWork)}
+	if len(ops) > 0 {
+		uow.ops = ops[0]
+	}
+	return uow
+}
+
+// WithContext 设置 Commit 使用的 ctx,钩子、操作人等从该 ctx 中读取
+func (u *UnitOfWork) WithContext(ctx context.Context) *UnitOfWork {
+	u.ctx = ctx
+	return u
+}
+
+func (u *UnitOfWork) getCtx() context.Context {
+	if u.ctx == nil {
+		return context.TODO()
+	}
+	return u.ctx
+}
+
+func (u *UnitOfWork) Len() int {
+	return len(u.works)
+}
+
+func unitTable[T Table](u *UnitOfWork, database *MongodbDatabase) *tableWork[T] {
+	if u.database == nil {
+		u.database = database
+	} else if u.database.GetRaw().Client() != database.GetRaw().Client() && u.err == nil {
+		u.err = ErrorUnitOfWorkDatabase
+	}
+	var r T
+	key := fmt.Sprintf("%s/%T", r.TableName(), r)
+	if work, ok := u.tables[key].(*tableWork[T]); ok {
+		return work
+	}
+	work := &tableWork[T]{}
+	u.tables[key] = work
+	u.works = append(u.works, work)
+	return work
+}
+
+func registerNew[T Table](u *UnitOfWork, database *MongodbDatabase, docs []T) {
+	work := unitTable[T](u, database)
+	work.inserts = append(work.inserts, docs...)
+}
+
+func registerDirty[T Table](u *UnitOfWork, database *MongodbDatabase, docs []T) {
+	work := unitTable[T](u, database)
+	for _, doc := range docs {
+		var expected int64
+		if v, ok := lookupHook[Versioned](doc, &doc); ok {
+			expected = v.GetVersion()
+		}
+		work.updates = append(work.updates, doc)
+		work.expected = append(work.expected, expected)
+	}
+}
+
+func registerDeleted[T Table](u *UnitOfWork, database *MongodbDatabase, ids []string) {
+	work := unitTable[T](u, database)
+	work.deletes = append(work.deletes, ids...)
+}
+
+// Commit 在一个事务中写入所有登记的变更,成功后清空登记;失败时事务回滚,登记保留,Versioned 文档恢复版本号
+func (u *UnitOfWork) Commit() error {
+	if u.err != nil {
+		return u.err
+	}
+	if len(u.works) == 0 {
+		return nil
+	}
+	for _, fn := range u.afterCommit {
+		defer fn()
+	}
+	err := u.database.WithTransaction(u.getCtx(), func(txCtx context.Context) error {
+		database := u.database.WithContext(txCtx)
+		for _, work := range u.works {
+			if err := work.flush(txCtx, database); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, u.ops)
+	if err != nil {
+		for _, work := range u.works {
+			work.rollback()
+		}
+		return err
+	}
+	u.Discard()
+	return nil
+}
+
+// Discard 丢弃所有登记的变更
+func (u *UnitOfWork) Discard() {
+	u.works = nil
+	u.tables = make(map[string]unitWork)
+	u.err = nil
+	u.afterCommit = nil
+}
+
+// RegisterNew 登记新增的文档,Commit 时写入
+func (i *MongodbGeneric[T]) RegisterNew(uow *UnitOfWork, docs ...T) {
+	i.invalidateOnCommit(uow)
+	registerNew(uow, i.database, docs)
+}
+
+// RegisterDirty 登记修改的文档,Commit 时按主键整文档替换,Versioned 文档按版本号条件替换
+func (i *MongodbGeneric[T]) RegisterDirty(uow *UnitOfWork, docs ...T) {
+	i.invalidateOnCommit(uow)
+	registerDirty(uow, i.database, docs)
+}
+
+// RegisterDeleted 登记删除的文档 id,SoftDeletable 文档为软删除
+func (i *MongodbGeneric[T]) RegisterDeleted(uow *UnitOfWork, ids ...string) {
+	i.invalidateOnCommit(uow)
+	registerDeleted[T](uow, i.database, ids)
+}
+
+func (i *MongodbGenericComplex[T]) RegisterNew(uow *UnitOfWork, docs ...T) {
+	uow.afterCommit = append(uow.afterCommit, i.markWrite)
+	registerNew(uow, i.writer, docs)
+}
+
+func (i *MongodbGenericComplex[T]) RegisterDirty(uow *UnitOfWork, docs ...T) {
+	uow.afterCommit = append(uow.afterCommit, i.markWrite)
+	registerDirty(uow, i.writer, docs)
+}
+
+func (i *MongodbGenericComplex[T]) RegisterDeleted(uow *UnitOfWork, ids ...string) {
+	uow.afterCommit = append(uow.afterCommit, i.markWrite)
+	registerDeleted[T](uow, i.writer, ids)
+}
+
+func (i *MongodbGeneric[T]) invalidateOnCommit(uow *UnitOfWork) {
+	if i.cache != nil {
+		uow.afterCommit = append(uow.afterCommit, i.invalidateAll)
+	}
+}
@@ -0,0 +1,34 @@
+package mongokits
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// versionedItem 值类型的表,Versioned 以指针接收者实现
+type versionedItem struct {
+	Id      primitive.ObjectID `bson:"_id"`
+	Version int64              `bson:"version"`
+}
+
+func (v versionedItem) TableName() string         { return "test_versioned_items" }
+func (v versionedItem) PrimaryKey() interface{}   { return v.Id }
+func (v versionedItem) PrimaryKeyName() string    { return "_id" }
+func (v *versionedItem) VersionField() string     { return "version" }
+func (v *versionedItem) GetVersion() int64        { return v.Version }
+func (v *versionedItem) SetVersion(version int64) { v.Version = version }
+
+func TestRegisterDirtyValueVersioned(t *testing.T) {
+	u := NewUnitOfWork()
+	registerDirty[versionedItem](u, nil, []versionedItem{{Id: primitive.NewObjectID(), Version: 3}})
+	work := unitTable[versionedItem](u, nil)
+	if len(work.expected) != 1 || work.expected[0] != 3 {
+		t.Fatalf("expected version 3 to be recorded, got %v", work.expected)
+	}
+	work.updates[0].Version = 4
+	work.rollback()
+	if work.updates[0].Version != 3 {
+		t.Fatalf("rollback should restore version 3, got %d", work.updates[0].Version)
+	}
+}
@@ -0,0 +1,261 @@
+package mongokits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Versioned Table 实现该接口后启用乐观锁,更新时按版本号条件更新并递增版本号
+// VersionField 返回版本号字段名(bson 名称)
+type Versioned interface {
+	VersionField() string
+	GetVersion() int64
+	SetVersion(version int64)
+}
+
+// VersionConflictError 文档已被其他人修改或不存在,Version 为更新时期望的版本号
+type VersionConflictError struct {
+	Table   string
+	Id      interface{}
+	Version int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict,table[%s] id[%v] version[%d]", e.Table, e.Id, e.Version)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrorVersionConflict
+}
+
+func versionField[T Table]() (string, bool) {
+	var r T
+	if v, ok := lookupHook[Versioned](r, &r); ok {
+		return v.VersionField(), true
+	}
+	return "", false
+}
+
+// updateDocument 整文档替换,Versioned 文档以期望版本号作为条件并在替换内容中递增版本号
+func updateDocument[T Table](database *MongodbDatabase, doc T) error {
+	filter := bson.M{"_id": doc.PrimaryKey()}
+	tenantCondition(doc, &doc, filter)
+	v, ok := lookupHook[Versioned](doc, &doc)
+	if !ok {
+		return database.Update(doc, filter)
+	}
+	expected := v.GetVersion()
+	filter[v.VersionField()] = expected
+	v.SetVersion(expected + 1)
+	err := database.Update(doc, filter)
+	if err != nil {
+		v.SetVersion(expected)
+	}
+	if IsNotFound(err) {
+		return &VersionConflictError{Table: doc.TableName(), Id: doc.PrimaryKey(), Version: expected}
+	}
+	return err
+}
+
+// replaceAll 按主键 upsert 替换,Versioned 文档版本不匹配时 upsert 会与 _id 冲突,转换为 VersionConflictError
+func replaceAll(ctx context.Context, database *MongodbDatabase, tableName string, tables []Table) (int64, int64, error) {
+	type versionState struct {
+		versioned Versioned
+		expected  int64
+	}
+	states := make(map[int]versionState)
+	var writers []mongo.WriteModel
+	for index, table := range tables {
+		filter := bson.M{table.PrimaryKeyName(): table.PrimaryKey()}
+		tenantCondition(table, nil, filter)
+		if v, ok := lookupHook[Versioned](table, nil); ok {
+			expected := v.GetVersion()
+			filter[v.VersionField()] = expected
+			v.SetVersion(expected + 1)
+			states[index] = versionState{versioned: v, expected: expected}
+		}
+		writers = append(writers, mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(table).SetUpsert(true))
+	}
+	result, err := database.bulkWrite(ctx, tableName, writers, options.BulkWrite().SetOrdered(false))
+	if err == nil {
+		return result.ModifiedCount, result.InsertedCount + result.UpsertedCount, nil
+	}
+	if result == nil {
+		result = &mongo.BulkWriteResult{}
+	}
+
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) {
+		for _, state := range states {
+			state.versioned.SetVersion(state.expected)
+		}
+		return 0, 0, wrapError(err)
+	}
+	var conflict error
+	for _, we := range bwe.WriteErrors {
+		state, ok := states[we.Index]
+		if !ok {
+			continue
+		}
+		state.versioned.SetVersion(state.expected)
+		if conflict == nil && IsDuplicateKey(wrapError(we.WriteError)) {
+			table := tables[we.Index]
+			conflict = &VersionConflictError{Table: tableName, Id: table.PrimaryKey(), Version: state.expected}
+		}
+	}
+	if conflict != nil {
+		return result.ModifiedCount, result.InsertedCount + result.UpsertedCount, conflict
+	}
+	return result.ModifiedCount, result.InsertedCount + result.UpsertedCount, wrapError(err)
+}
+
+// updateSet 按字段更新,Versioned 文档自动 $inc 版本号,条件中包含版本号且未匹配时返回 VersionConflictError
+func updateSet[T Table](ctx context.Context, database *MongodbDatabase, cond bson.M, setter bson.M) error {
+	var r T
+	filter, err := tenantFilter[T](ctx, cond)
+	if err != nil {
+		return err
+	}
+	if err := tenantSetter[T](ctx, setter); err != nil {
+		return err
+	}
+	update := touchSetter[T](ctx, setter, false)
+	field, versioned := versionField[T]()
+	if versioned {
+		update = incVersion(update, field)
+	}
+	result, err := database.updateOne(ctx, r.TableName(), filter, update)
+	if err != nil {
+		return wrapError(err)
+	}
+	if expected, exists := cond[field]; versioned && exists && result.MatchedCount == 0 {
+		return &VersionConflictError{Table: r.TableName(), Id: cond["_id"], Version: versionNumber(expected)}
+	}
+	return nil
+}
+
+// versionNumber 将条件中的版本号统一转换为 int64,条件中的版本号可能是任意整数类型
+func versionNumber(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int8:
+		return int64(n)
+	case int16:
+		return int64(n)
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case uint:
+		return int64(n)
+	case uint8:
+		return int64(n)
+	case uint16:
+		return int64(n)
+	case uint32:
+		return int64(n)
+	case uint64:
+		return int64(n)
+	case float32:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+func incVersion(update interface{}, field string) interface{} {
+	m, ok := update.(bson.M)
+	if !ok || fieldAssigned(m, field) {
+		return update
+	}
+	result := bson.M{}
+	for k, v := range m {
+		result[k] = v
+	}
+	setOperatorField(result, "$inc", field, 1)
+	return result
+}
+
+// asTables 转换为 []Table,值类型 T 使用元素指针,使指针接收者实现的 Versioned 等接口生效
+func asTables[T Table](docs []T) []Table {
+	tables := make([]Table, len(docs))
+	for index := range docs {
+		if t, ok := interface{}(&docs[index]).(Table); ok {
+			tables[index] = t
+		} else {
+			tables[index] = docs[index]
+		}
+	}
+	return tables
+}
+
+// RetryOnConflict 执行 fn,返回版本冲突时重新执行,最多执行 attempts 次
+func RetryOnConflict(attempts int, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < attempts || attempt == 0; attempt++ {
+		if err = fn(); !errors.Is(err, ErrorVersionConflict) {
+			return err
+		}
+	}
+	return err
+}
+
+// UpdateWithRetry 读取最新文档,应用 mutate 后更新,版本冲突时重新读取并重试
+func (i *MongodbGeneric[T]) UpdateWithRetry(id string, attempts int, mutate func(doc T) (T, error)) (T, error) {
+	var result T
+	err := RetryOnConflict(attempts, func() error {
+		doc, err := i.GetById(id)
+		if err != nil {
+			return err
+		}
+		if doc, err = mutate(doc); err != nil {
+			return err
+		}
+		result = doc
+		return i.Update(doc)
+	})
+	return result, err
+}
+
+func (i *MongodbGenericComplex[T]) UpdateWithRetry(id string, attempts int, mutate func(doc T) (T, error)) (T, error) {
+	var result T
+	err := RetryOnConflict(attempts, func() error {
+		// 从写库读取,避免读到从库的旧版本
+		doc, err := queryById[T](i.getCtx(), i.writer, i.scope, id)
+		if err != nil {
+			return err
+		}
+		if doc, err = mutate(doc); err != nil {
+			return err
+		}
+		result = doc
+		return i.Update(doc)
+	})
+	return result, err
+}
+
+func UpdateWithRetry[T Table](id string, attempts int, mutate func(doc T) (T, error)) (T, error) {
+	return UpdateWithRetryContext[T](context.TODO(), id, attempts, mutate)
+}
+
+func UpdateWithRetryContext[T Table](ctx context.Context, id string, attempts int, mutate func(doc T) (T, error)) (T, error) {
+	var result T
+	err := RetryOnConflict(attempts, func() error {
+		doc, err := GetByIdContext[T](ctx, id)
+		if err != nil {
+			return err
+		}
+		if doc, err = mutate(doc); err != nil {
+			return err
+		}
+		result = doc
+		return UpdateContext(ctx, doc)
+	})
+	return result, err
+}
@@ -0,0 +1,76 @@
+package mongokits
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 整文档更新以期望版本号为条件,未匹配时返回 VersionConflictError 并恢复文档的版本号
+func TestUpdateDocumentVersionConflict(t *testing.T) {
+	var filters []interface{}
+	var versions []int64
+	database := newFakeDatabase(t, func(ctx context.Context, op *Operation, next Invoker) error {
+		filters = append(filters, op.Filter)
+		versions = append(versions, op.Document.(stampedItem).Version)
+		return mongo.ErrNoDocuments
+	})
+	doc := stampedItem{Id: primitive.NewObjectID(), Version: 3}
+	err := updateDocument(database, doc)
+	var conflict *VersionConflictError
+	if !errors.As(err, &conflict) || !IsVersionConflict(err) || conflict.Version != 3 || conflict.Id != doc.Id {
+		t.Fatalf("expected version conflict, got %v", err)
+	}
+	if !reflect.DeepEqual(filters[0], bson.M{"_id": doc.Id, "version": int64(3)}) || versions[0] != 4 {
+		t.Fatalf("unexpected filter %v version %v", filters[0], versions[0])
+	}
+}
+
+// 按字段更新时自动递增版本号,条件中的版本号为任意整数类型时冲突错误均报告该版本号
+func TestUpdateSetVersionConflict(t *testing.T) {
+	var update interface{}
+	database := newFakeDatabase(t, func(ctx context.Context, op *Operation, next Invoker) error {
+		update = op.Update
+		op.Result = &mongo.UpdateResult{}
+		return nil
+	})
+	for _, expected := range []interface{}{7, int32(7), int64(7), 7.0} {
+		err := updateSet[stampedItem](context.Background(), database, bson.M{"_id": 1, "version": expected}, bson.M{"$set": bson.M{"name": "a"}})
+		var conflict *VersionConflictError
+		if !errors.As(err, &conflict) || conflict.Version != 7 {
+			t.Fatalf("version %T: expected conflict on version 7, got %v", expected, err)
+		}
+	}
+	if inc := update.(bson.M)["$inc"]; !reflect.DeepEqual(inc, bson.M{"version": 1}) {
+		t.Fatalf("version not incremented: %v", update)
+	}
+	if err := updateSet[stampedItem](context.Background(), database, bson.M{"_id": 1}, bson.M{"$set": bson.M{"name": "a"}}); err != nil {
+		t.Fatalf("update without version condition: %v", err)
+	}
+}
+
+func TestRetryOnConflict(t *testing.T) {
+	calls := 0
+	err := RetryOnConflict(3, func() error {
+		calls++
+		return &VersionConflictError{}
+	})
+	if calls != 3 || !IsVersionConflict(err) {
+		t.Fatalf("calls %d err %v", calls, err)
+	}
+	calls = 0
+	if err := RetryOnConflict(3, func() error {
+		calls++
+		if calls < 2 {
+			return &VersionConflictError{}
+		}
+		return nil
+	}); err != nil || calls != 2 {
+		t.Fatalf("calls %d err %v", calls, err)
+	}
+}
@@ -0,0 +1,96 @@
+package mongokits
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// testItem 测试用的普通表
+type testItem struct {
+	Id   primitive.ObjectID `bson:"_id"`
+	Name string             `bson:"name"`
+}
+
+func (t *testItem) TableName() string       { return "test_items" }
+func (t *testItem) PrimaryKey() interface{} { return t.Id }
+func (t *testItem) PrimaryKeyName() string  { return "_id" }
+
+// 集成测试需要 MongoDB,通过 MONGODB_TEST_SERVER 指定连接串(事务相关测试需为副本集),未设置时跳过
+const testServerEnv = "MONGODB_TEST_SERVER"
+
+var (
+	testOnce     sync.Once
+	testDb       *MongodbDatabase
+	testDbErr    error
+	testDatabase = fmt.Sprintf("mongokits_test_%d", time.Now().UnixNano())
+)
+
+// openTestDatabase 注册 default 数据源,测试结束时删除 collections 中的集合
+func openTestDatabase(t *testing.T, collections ...string) *MongodbDatabase {
+	t.Helper()
+	server := os.Getenv(testServerEnv)
+	if server == "" {
+		t.Skipf("%s not set", testServerEnv)
+	}
+	testOnce.Do(func() {
+		ops := (&MongoOptions{}).Name("default").Server(server).Database(testDatabase).TimeOut(10)
+		creator := NewMongodbCreator(ops)
+		GetDefaultManager().SetDatabaseFactory(creator)
+		testDb, testDbErr = creator.getDatabase()
+	})
+	if testDbErr != nil {
+		t.Fatal(testDbErr)
+	}
+	drop := func() {
+		for _, name := range collections {
+			_ = testDb.GetRaw().Collection(name).Drop(context.Background())
+		}
+	}
+	drop()
+	t.Cleanup(drop)
+	return testDb
+}
+
+// newFakeDatabase 返回未连接服务器的数据库,所有操作由 interceptor 处理,interceptor 不应调用 next
+func newFakeDatabase(t *testing.T, interceptor Interceptor) *MongodbDatabase {
+	t.Helper()
+	raw, err := mongo.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := (&MongoOptions{}).Name("fake").Interceptors(interceptor)
+	client := &MongoClient{database: raw.Database("test"), options: ops, duration: time.Second}
+	return &MongodbDatabase{client: client, options: ops}
+}
+
+// fakeFactory 按数据源 id 返回预先创建的数据库,空 id 为默认数据源
+type fakeFactory map[string]*MongodbDatabase
+
+func (f fakeFactory) getDatabase() (*MongodbDatabase, error) {
+	return f.getDatabaseById("")
+}
+
+func (f fakeFactory) getDatabaseById(id string) (*MongodbDatabase, error) {
+	if database, ok := f[id]; ok {
+		return database, nil
+	}
+	return nil, fmt.Errorf("datasource %s not found", id)
+}
+
+// useFakeFactory 替换默认管理器的数据源与租户解析器,测试结束时恢复
+func useFakeFactory(t *testing.T, databases map[string]*MongodbDatabase) {
+	t.Helper()
+	manager := GetDefaultManager().(*defaultManager)
+	factory, resolver := manager.factory, manager.resolver
+	manager.factory, manager.resolver = fakeFactory(databases), nil
+	t.Cleanup(func() {
+		manager.factory, manager.resolver = factory, resolver
+	})
+}
@@ -0,0 +1,213 @@
+/*
+*
+Package otelmongokits 通过驱动的命令与连接池监听器为 mongokits 数据源接入 OpenTelemetry 追踪与指标
+独立模块,不使用 OpenTelemetry 的项目无需引入该依赖
+*/
+package otelmongokits
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	mongokits "github.com/penjon/jomongokits"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/penjon/jomongokits/otelmongokits"
+
+// 属性名,与 OpenTelemetry 数据库语义约定一致
+const (
+	AttrDBSystem     = attribute.Key("db.system")
+	AttrDBName       = attribute.Key("db.name")
+	AttrDBOperation  = attribute.Key("db.operation")
+	AttrDBCollection = attribute.Key("db.mongodb.collection")
+	AttrDatasource   = attribute.Key("mongokits.datasource")
+	AttrOutcome      = attribute.Key("outcome")
+)
+
+// 指标名
+const (
+	MetricOperationDuration = "db.client.operation.duration"
+	MetricOperationErrors   = "db.client.operation.errors"
+	MetricPoolCheckouts     = "db.client.connection.checkouts"
+	MetricPoolSize          = "db.client.connection.count"
+)
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+}
+
+type Option func(c *config)
+
+// WithTracerProvider 默认使用 otel.GetTracerProvider()
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = provider
+	}
+}
+
+// WithMeterProvider 默认使用 otel.GetMeterProvider()
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = provider
+	}
+}
+
+/*
+*
+Instrument 为数据源注册命令与连接池监听器,需在创建客户端(NewMongodbCreator)前调用
+每个命令一个 Span,指标按数据源 id 记录命令耗时、错误数、连接获取次数与连接数
+*/
+func Instrument(ops *mongokits.MongoOptions, opts ...Option) (*mongokits.MongoOptions, error) {
+	c := &config{
+		tracerProvider: otel.GetTracerProvider(),
+		meterProvider:  otel.GetMeterProvider(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	t, err := newTelemetry(ops.Id, c)
+	if err != nil {
+		return nil, err
+	}
+	return ops.CommandMonitor(t.commandMonitor()).PoolMonitor(t.poolMonitor()), nil
+}
+
+type commandState struct {
+	span       trace.Span
+	operation  string
+	collection string
+}
+
+type commandKey struct {
+	connection string
+	request    int64
+}
+
+type telemetry struct {
+	datasource attribute.KeyValue
+	tracer     trace.Tracer
+	duration   metric.Float64Histogram
+	errors     metric.Int64Counter
+	checkouts  metric.Int64Counter
+	poolSize   metric.Int64UpDownCounter
+	commands   sync.Map
+}
+
+func newTelemetry(datasourceId string, c *config) (*telemetry, error) {
+	meter := c.meterProvider.Meter(instrumentationName)
+	t := &telemetry{
+		datasource: AttrDatasource.String(datasourceId),
+		tracer:     c.tracerProvider.Tracer(instrumentationName),
+	}
+	var err, e error
+	t.duration, e = meter.Float64Histogram(MetricOperationDuration,
+		metric.WithUnit("s"), metric.WithDescription("Duration of mongodb commands"))
+	err = errors.Join(err, e)
+	t.errors, e = meter.Int64Counter(MetricOperationErrors,
+		metric.WithDescription("Number of failed mongodb commands"))
+	err = errors.Join(err, e)
+	t.checkouts, e = meter.Int64Counter(MetricPoolCheckouts,
+		metric.WithDescription("Number of connection checkouts, outcome is success or failure"))
+	err = errors.Join(err, e)
+	t.poolSize, e = meter.Int64UpDownCounter(MetricPoolSize,
+		metric.WithDescription("Number of open connections in the pool"))
+	err = errors.Join(err, e)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *telemetry) commandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: t.started,
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			t.finished(ctx, e.CommandFinishedEvent, nil)
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			t.finished(ctx, e.CommandFinishedEvent, errors.New(e.Failure))
+		},
+	}
+}
+
+func (t *telemetry) started(ctx context.Context, e *event.CommandStartedEvent) {
+	state := &commandState{operation: e.CommandName, collection: commandCollection(e.Command, e.CommandName)}
+	name := state.operation
+	if state.collection != "" {
+		name += " " + state.collection
+	}
+	_, state.span = t.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			AttrDBSystem.String("mongodb"),
+			AttrDBName.String(e.DatabaseName),
+			AttrDBOperation.String(state.operation),
+			AttrDBCollection.String(state.collection),
+			t.datasource,
+		))
+	t.commands.Store(commandKey{connection: e.ConnectionID, request: e.RequestID}, state)
+}
+
+func (t *telemetry) finished(ctx context.Context, e event.CommandFinishedEvent, err error) {
+	value, ok := t.commands.LoadAndDelete(commandKey{connection: e.ConnectionID, request: e.RequestID})
+	if !ok {
+		return
+	}
+	state := value.(*commandState)
+	if err != nil {
+		state.span.RecordError(err)
+		state.span.SetStatus(codes.Error, err.Error())
+	}
+	state.span.End()
+
+	attrs := metric.WithAttributes(t.datasource,
+		AttrDBSystem.String("mongodb"),
+		AttrDBOperation.String(state.operation),
+		AttrDBCollection.String(state.collection))
+	t.duration.Record(ctx, time.Duration(e.DurationNanos).Seconds(), attrs)
+	if err != nil {
+		t.errors.Add(ctx, 1, attrs)
+	}
+}
+
+// commandCollection 命令的目标集合,getMore 的集合在 collection 字段中,数据库级命令返回空
+func commandCollection(command bson.Raw, name string) string {
+	key := name
+	if name == "getMore" {
+		key = "collection"
+	}
+	value, err := command.LookupErr(key)
+	if err != nil || value.Type != bsontype.String {
+		return ""
+	}
+	return value.StringValue()
+}
+
+func (t *telemetry) poolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			ctx := context.Background()
+			switch e.Type {
+			case event.GetSucceeded:
+				t.checkouts.Add(ctx, 1, metric.WithAttributes(t.datasource, AttrOutcome.String("success")))
+			case event.GetFailed:
+				t.checkouts.Add(ctx, 1, metric.WithAttributes(t.datasource, AttrOutcome.String("failure")))
+			case event.ConnectionCreated:
+				t.poolSize.Add(ctx, 1, metric.WithAttributes(t.datasource))
+			case event.ConnectionClosed:
+				t.poolSize.Add(ctx, -1, metric.WithAttributes(t.datasource))
+			}
+		},
+	}
+}
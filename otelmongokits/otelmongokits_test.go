@@ -0,0 +1,174 @@
+package otelmongokits
+
+import (
+	"context"
+	"testing"
+
+	mongokits "github.com/penjon/jomongokits"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTestTelemetry(t *testing.T) (*telemetry, *tracetest.SpanRecorder, *sdkmetric.ManualReader) {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	tel, err := newTelemetry("orders-db", &config{
+		tracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+		meterProvider:  sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tel, recorder, reader
+}
+
+func command(t *testing.T, doc bson.D) bson.Raw {
+	t.Helper()
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func collect(t *testing.T, reader *sdkmetric.ManualReader) map[string]metricdata.Metrics {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[string]metricdata.Metrics)
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			result[m.Name] = m
+		}
+	}
+	return result
+}
+
+func TestCommandSpans(t *testing.T) {
+	tel, recorder, _ := newTestTelemetry(t)
+	monitor := tel.commandMonitor()
+	ctx := context.Background()
+
+	monitor.Started(ctx, &event.CommandStartedEvent{
+		Command:      command(t, bson.D{{Key: "find", Value: "orders"}, {Key: "filter", Value: bson.M{}}}),
+		DatabaseName: "shop", CommandName: "find", RequestID: 1, ConnectionID: "c1",
+	})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "find", RequestID: 1, ConnectionID: "c1", DurationNanos: 2e6},
+	})
+	monitor.Started(ctx, &event.CommandStartedEvent{
+		Command:      command(t, bson.D{{Key: "insert", Value: "orders"}}),
+		DatabaseName: "shop", CommandName: "insert", RequestID: 2, ConnectionID: "c1",
+	})
+	monitor.Failed(ctx, &event.CommandFailedEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "insert", RequestID: 2, ConnectionID: "c1", DurationNanos: 1e6},
+		Failure:              "duplicate key",
+	})
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Name() != "find orders" {
+		t.Errorf("unexpected span name %q", spans[0].Name())
+	}
+	attrs := attribute.NewSet(spans[0].Attributes()...)
+	for key, want := range map[attribute.Key]string{
+		AttrDBSystem:     "mongodb",
+		AttrDBName:       "shop",
+		AttrDBOperation:  "find",
+		AttrDBCollection: "orders",
+		AttrDatasource:   "orders-db",
+	} {
+		if got, _ := attrs.Value(key); got.AsString() != want {
+			t.Errorf("%s = %q, want %q", key, got.AsString(), want)
+		}
+	}
+	if spans[1].Status().Code != codes.Error || len(spans[1].Events()) == 0 {
+		t.Errorf("failed command should record error, got status %v", spans[1].Status())
+	}
+}
+
+func TestChildSpanOfCaller(t *testing.T) {
+	tel, recorder, _ := newTestTelemetry(t)
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "GetById")
+	monitor := tel.commandMonitor()
+	monitor.Started(ctx, &event.CommandStartedEvent{
+		Command: command(t, bson.D{{Key: "find", Value: "orders"}}), CommandName: "find", RequestID: 7, ConnectionID: "c2",
+	})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "find", RequestID: 7, ConnectionID: "c2"},
+	})
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("command span should be a child of the caller span")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	tel, _, reader := newTestTelemetry(t)
+	monitor := tel.commandMonitor()
+	ctx := context.Background()
+	for request := int64(1); request <= 3; request++ {
+		monitor.Started(ctx, &event.CommandStartedEvent{
+			Command: command(t, bson.D{{Key: "find", Value: "orders"}}), CommandName: "find", RequestID: request, ConnectionID: "c1",
+		})
+	}
+	finished := func(request int64) event.CommandFinishedEvent {
+		return event.CommandFinishedEvent{CommandName: "find", RequestID: request, ConnectionID: "c1", DurationNanos: 5e6}
+	}
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: finished(1)})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{CommandFinishedEvent: finished(2)})
+	monitor.Failed(ctx, &event.CommandFailedEvent{CommandFinishedEvent: finished(3), Failure: "timeout"})
+
+	pool := tel.poolMonitor()
+	pool.Event(&event.PoolEvent{Type: event.ConnectionCreated})
+	pool.Event(&event.PoolEvent{Type: event.ConnectionCreated})
+	pool.Event(&event.PoolEvent{Type: event.ConnectionClosed})
+	pool.Event(&event.PoolEvent{Type: event.GetSucceeded})
+	pool.Event(&event.PoolEvent{Type: event.GetFailed})
+
+	metrics := collect(t, reader)
+	duration := metrics[MetricOperationDuration].Data.(metricdata.Histogram[float64])
+	if len(duration.DataPoints) != 1 || duration.DataPoints[0].Count != 3 {
+		t.Errorf("unexpected duration data points %+v", duration.DataPoints)
+	}
+	errs := metrics[MetricOperationErrors].Data.(metricdata.Sum[int64])
+	if len(errs.DataPoints) != 1 || errs.DataPoints[0].Value != 1 {
+		t.Errorf("unexpected error data points %+v", errs.DataPoints)
+	}
+	size := metrics[MetricPoolSize].Data.(metricdata.Sum[int64])
+	if len(size.DataPoints) != 1 || size.DataPoints[0].Value != 1 {
+		t.Errorf("unexpected pool size %+v", size.DataPoints)
+	}
+	if v, _ := size.DataPoints[0].Attributes.Value(AttrDatasource); v.AsString() != "orders-db" {
+		t.Errorf("pool size should be recorded per datasource, got %q", v.AsString())
+	}
+	checkouts := metrics[MetricPoolCheckouts].Data.(metricdata.Sum[int64])
+	if len(checkouts.DataPoints) != 2 {
+		t.Errorf("expected success and failure checkouts, got %+v", checkouts.DataPoints)
+	}
+}
+
+func TestInstrumentRegistersMonitors(t *testing.T) {
+	ops := (&mongokits.MongoOptions{}).Name("orders-db")
+	if _, err := Instrument(ops, WithTracerProvider(sdktrace.NewTracerProvider()),
+		WithMeterProvider(sdkmetric.NewMeterProvider())); err != nil {
+		t.Fatal(err)
+	}
+}